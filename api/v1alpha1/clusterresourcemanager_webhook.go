@@ -0,0 +1,88 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var clusterresourcemanagerlog = logf.Log.WithName("clusterresourcemanager-resource")
+
+func (r *ClusterResourceManager) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-resource-management-tikalk-com-v1alpha1-clusterresourcemanager,mutating=true,failurePolicy=fail,sideEffects=None,groups=resource-management.tikalk.com,resources=clusterresourcemanagers,verbs=create;update,versions=v1alpha1,name=mclusterresourcemanager.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Defaulter = &ClusterResourceManager{}
+
+// Default implements webhook.Defaulter so a webhook will be registered for the type
+func (r *ClusterResourceManager) Default() {
+	clusterresourcemanagerlog.Info("default", "name", r.Name)
+
+	r.Spec.setDefaults()
+}
+
+//+kubebuilder:webhook:path=/validate-resource-management-tikalk-com-v1alpha1-clusterresourcemanager,mutating=false,failurePolicy=fail,sideEffects=None,groups=resource-management.tikalk.com,resources=clusterresourcemanagers,verbs=create;update,versions=v1alpha1,name=vclusterresourcemanager.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &ClusterResourceManager{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *ClusterResourceManager) ValidateCreate() error {
+	clusterresourcemanagerlog.Info("validate create", "name", r.Name)
+
+	return r.Validate()
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *ClusterResourceManager) ValidateUpdate(old runtime.Object) error {
+	clusterresourcemanagerlog.Info("validate update", "name", r.Name)
+
+	if oldResourceManager, ok := old.(*ClusterResourceManager); ok && !validatesUpdate(r, &oldResourceManager.Spec, &r.Spec) {
+		return nil
+	}
+	return r.Validate()
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *ClusterResourceManager) ValidateDelete() error {
+	clusterresourcemanagerlog.Info("validate delete", "name", r.Name)
+
+	return nil
+}
+
+// Validate checks that the spec can be handled, the controller uses it as well for objects that bypassed the webhook
+func (r *ClusterResourceManager) Validate() error {
+	path := field.NewPath("spec")
+	allErrs := r.Spec.validate(path)
+	if r.Spec.Notifications != nil && r.Spec.Notifications.SecretRef.Namespace == "" {
+		allErrs = append(allErrs, field.Required(path.Child("notifications", "secretRef", "namespace"), "a ClusterResourceManager references the Secret by its namespace"))
+	}
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(schema.GroupKind{Group: GroupVersion.Group, Kind: "ClusterResourceManager"}, r.Name, allErrs)
+}
@@ -0,0 +1,57 @@
+package v1alpha1
+
+import (
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// newClusterResourceManager returns a valid cluster resource manager to be modified by the tests
+func newClusterResourceManager(name string) *ClusterResourceManager {
+	return &ClusterResourceManager{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Spec: newResourceManager(name).Spec,
+	}
+}
+
+var _ = Context("ClusterResourceManager webhooks", func() {
+	Describe("defaulting", func() {
+		It("should set the apiVersion of a well known kind", func() {
+			resourceManager := newClusterResourceManager("test-cluster-defaulting")
+			resourceManager.Spec.ResourceKind = "Namespace"
+			Expect(k8sClient.Create(ctx, resourceManager)).To(Succeed())
+			Expect(resourceManager.Spec.ResourceAPIVersion).To(Equal("v1"))
+		})
+	})
+
+	Describe("validation", func() {
+		It("should accept namespaced kinds across selected namespaces", func() {
+			resourceManager := newClusterResourceManager("test-cluster-valid")
+			resourceManager.Spec.NamespaceSelector = &metav1.LabelSelector{
+				MatchLabels: map[string]string{"team": "a"},
+			}
+			resourceManager.Spec.Namespaces = []string{"qa1", "qa2"}
+			Expect(k8sClient.Create(ctx, resourceManager)).To(Succeed())
+		})
+
+		table.DescribeTable("should reject an invalid spec",
+			func(name string, mutate func(spec *ResourceManagerSpec)) {
+				resourceManager := newClusterResourceManager(name)
+				mutate(&resourceManager.Spec)
+				Expect(k8sClient.Create(ctx, resourceManager)).NotTo(Succeed())
+			},
+			table.Entry("unparsable after", "test-cluster-invalid-after", func(spec *ResourceManagerSpec) {
+				spec.Condition.ExpireAfter = "tomorrow"
+			}),
+			table.Entry("invalid namespace", "test-cluster-invalid-namespaces", func(spec *ResourceManagerSpec) {
+				spec.Namespaces = []string{"Team_A"}
+			}),
+			table.Entry("notifications secret without a namespace", "test-cluster-invalid-notifications", func(spec *ResourceManagerSpec) {
+				spec.Notifications = &Notifications{SecretRef: SecretKeyReference{Name: "webhook"}}
+			}),
+		)
+	})
+})
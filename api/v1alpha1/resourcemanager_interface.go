@@ -0,0 +1,65 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ResourceManagerObject is implemented by ResourceManager and ClusterResourceManager, which share the spec and the status
+// +kubebuilder:object:generate=false
+type ResourceManagerObject interface {
+	client.Object
+	// GetSpec returns the spec of the resource manager
+	GetSpec() *ResourceManagerSpec
+	// GetStatus returns the status of the resource manager
+	GetStatus() *ResourceManagerStatus
+	// IsNamespaced returns true when the resource manager manages the objects of its own namespace only
+	IsNamespaced() bool
+	// Validate checks that the spec can be handled
+	Validate() error
+}
+
+// GetSpec returns the spec of the resource manager
+func (r *ResourceManager) GetSpec() *ResourceManagerSpec {
+	return &r.Spec
+}
+
+// GetStatus returns the status of the resource manager
+func (r *ResourceManager) GetStatus() *ResourceManagerStatus {
+	return &r.Status
+}
+
+// IsNamespaced returns true, a ResourceManager manages the objects of its own namespace only
+func (r *ResourceManager) IsNamespaced() bool {
+	return true
+}
+
+// GetSpec returns the spec of the resource manager
+func (r *ClusterResourceManager) GetSpec() *ResourceManagerSpec {
+	return &r.Spec
+}
+
+// GetStatus returns the status of the resource manager
+func (r *ClusterResourceManager) GetStatus() *ResourceManagerStatus {
+	return &r.Status
+}
+
+// IsNamespaced returns false, a ClusterResourceManager manages objects across the cluster
+func (r *ClusterResourceManager) IsNamespaced() bool {
+	return false
+}
@@ -17,6 +17,8 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"time"
+
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -31,31 +33,232 @@ type ResourceManagerSpec struct {
 	Disabled bool `json:"disabled,omitempty"`
 	DryRun   bool `json:"dry-run,omitempty"`
 
-	//ManagedResource ResourceSelector `json:",inline"`
+	// ResourceAPIVersion is the group/version of the managed kind (e.g. "apps/v1", "batch/v1").
+	// When empty, the preferred version served by the cluster for ResourceKind is used.
+	ResourceAPIVersion string `json:"resourceApiVersion,omitempty"`
+	// ResourceKind is the kind of the managed objects, any built-in or CRD-backed kind is supported.
 	ResourceKind string                `json:"resourceKind"`
 	Selector     *metav1.LabelSelector `json:"selector"`
-	//NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// NamespaceSelector selects the namespaces of namespaced objects by their labels, it is set on a ClusterResourceManager only.
+	// Objects in namespaces that match either NamespaceSelector or Namespaces are managed. When both are omitted,
+	// a ClusterResourceManager manages the objects of all the namespaces, and a ResourceManager those of its own namespace.
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// Namespaces lists the namespaces of namespaced objects
+	Namespaces []string `json:"namespaces,omitempty"`
 
-	// TODO: add validation + enum
-	Action      string `json:"action"`
+	// Action is performed on the objects when they expire
+	//+kubebuilder:validation:Enum=delete;patch;scale
+	Action string `json:"action"`
+	// ActionParam is the patch of the patch action, it may be a Go text/template of the object (ex: {{ .Name }})
 	ActionParam string `json:"actionParam,omitempty"`
+	// PatchType is the type of the patch action param, strategic merge patch when omitted
+	//+kubebuilder:validation:Enum=strategic;merge;json;apply
+	PatchType string `json:"patchType,omitempty"`
+	// Scale configures the scale action
+	Scale *ScaleAction `json:"scale,omitempty"`
 
 	Condition Expiration `json:"expiration"`
+	// Recurrence is "every" to perform the action on every occurrence of 'at' or 'schedule', or "once" to perform it once.
+	// It is "every" when omitted for the actions that keep the objects (patch, scale) and 'at' or 'schedule', "once" otherwise.
+	//+kubebuilder:validation:Enum=once;every
+	Recurrence string `json:"recurrence,omitempty"`
+	// WarnBefore lists the lead times (ex: ["1h", "10m"]) before the action at which a warning event is recorded
+	// on the object and on the resource manager
+	WarnBefore []string `json:"warnBefore,omitempty"`
+	// Retry retries the failed actions with an exponential backoff, the defaults are used when omitted
+	Retry *RetryPolicy `json:"retry,omitempty"`
+	// Notifications posts the warnings and the action results to a webhook (ex: Slack, Teams)
+	Notifications *Notifications `json:"notifications,omitempty"`
+	// Lease limits extending the expiration of the objects by their snooze annotations,
+	// the extensions are unlimited when omitted
+	Lease *LeasePolicy `json:"lease,omitempty"`
+	// OnDelete is applied to the managed objects when the resource manager is deleted, "stop" when omitted:
+	// "stop" stops tracking the objects, "execute" performs their pending actions immediately and
+	// "revert" reverts the patches and the scales performed on them
+	//+kubebuilder:validation:Enum=stop;execute;revert
+	OnDelete string `json:"onDelete,omitempty"`
 }
 
 type Expiration struct {
 	ExpireAt    string `json:"at,omitempty"`
 	ExpireAfter string `json:"after,omitempty"`
+	// Schedule is a standard 5-field cron expression (ex: "0 20 * * 1-5"), the action is performed on its next occurrence
+	Schedule string `json:"schedule,omitempty"`
+	// Window keeps the objects in the state of the action while the window is open,
+	// the action is performed when the window opens and the exit action when it closes
+	Window *Window `json:"window,omitempty"`
+	// TimeZone is the IANA name of the location (ex: "Asia/Jerusalem") in which 'at', 'schedule' and 'window' are evaluated,
+	// the local time of the operator is used when omitted
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+// Window is a recurring time window (ex: "between 20:00 and 07:00 on weekdays, and all weekend")
+type Window struct {
+	// Start is a standard 5-field cron expression of the times the window opens (ex: "0 20 * * 1-5")
+	Start string `json:"start"`
+	// End is a standard 5-field cron expression of the times the window closes (ex: "0 7 * * 1-5")
+	End string `json:"end"`
+	// ExitActionParam is the patch applied when the window closes for the patch action, it may be a template as the actionParam.
+	// The objects scaled by the scale action are scaled back to their previous replicas when the window closes.
+	ExitActionParam string `json:"exitActionParam,omitempty"`
+}
+
+// Location returns the location in which the wall-clock expirations are evaluated
+func (e *Expiration) Location() (*time.Location, error) {
+	if e.TimeZone == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(e.TimeZone)
+}
+
+// ScaleAction scales the objects (ex: Deployments, StatefulSets) through their scale subresource
+type ScaleAction struct {
+	// Replicas is the replica count the objects are scaled to
+	//+kubebuilder:validation:Minimum=0
+	Replicas int32 `json:"replicas"`
+	// Restore schedules scaling the objects back to their previous replica count,
+	// an 'after' restore is counted from the time the objects were scaled
+	Restore *Expiration `json:"restore,omitempty"`
+}
+
+// Defaults of the retry policy
+const (
+	DefaultRetryMaxAttempts = 5
+	DefaultRetryBackoff     = 10 * time.Second
+	DefaultRetryMaxBackoff  = 10 * time.Minute
+)
+
+// RetryPolicy retries a failed action, the delay before a retry doubles on every attempt
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts of an action including the first one, 5 when omitted (1 disables the retries)
+	//+kubebuilder:validation:Minimum=1
+	MaxAttempts *int32 `json:"maxAttempts,omitempty"`
+	// Backoff is the delay before the first retry, "10s" when omitted
+	Backoff string `json:"backoff,omitempty"`
+	// MaxBackoff caps the delay between the retries, "10m" when omitted
+	MaxBackoff string `json:"maxBackoff,omitempty"`
+}
+
+// Limits returns the retry policy with the defaults of the omitted fields, a nil policy has the defaults
+func (p *RetryPolicy) Limits() (maxAttempts int32, backoff time.Duration, maxBackoff time.Duration, err error) {
+	maxAttempts, backoff, maxBackoff = DefaultRetryMaxAttempts, DefaultRetryBackoff, DefaultRetryMaxBackoff
+	if p == nil {
+		return maxAttempts, backoff, maxBackoff, nil
+	}
+	if p.MaxAttempts != nil {
+		maxAttempts = *p.MaxAttempts
+	}
+	if p.Backoff != "" {
+		if backoff, err = time.ParseDuration(p.Backoff); err != nil {
+			return maxAttempts, backoff, maxBackoff, err
+		}
+	}
+	if p.MaxBackoff != "" {
+		if maxBackoff, err = time.ParseDuration(p.MaxBackoff); err != nil {
+			return maxAttempts, backoff, maxBackoff, err
+		}
+	}
+	return maxAttempts, backoff, maxBackoff, nil
+}
+
+// LeasePolicy limits the extensions of the expiration requested by the owners of the objects
+type LeasePolicy struct {
+	// MaxExtensions is the number of times the expiration of an object may be extended
+	//+kubebuilder:validation:Minimum=0
+	MaxExtensions *int32 `json:"maxExtensions,omitempty"`
+	// MaxLifetime is the latest expiration of an object counted from its creation (ex: "168h"),
+	// extensions and override annotations beyond it are shortened to it
+	MaxLifetime string `json:"maxLifetime,omitempty"`
+}
+
+// DefaultNotificationTemplate is the payload of the notifications when it is omitted, it is understood by Slack and Teams
+const DefaultNotificationTemplate = `{"text": {{ json (printf "[%s] %s %s: %s" .Reason .Kind .Object .Message) }}}`
+
+// Notifications posts the warnings and the action results to a webhook
+type Notifications struct {
+	// SecretRef references the Secret holding the webhook URL
+	SecretRef SecretKeyReference `json:"secretRef"`
+	// PayloadTemplate is a Go text/template of the JSON payload, its data is the notification
+	// (.Reason, .Message, .Kind, .Object, .Name, .Namespace, .ResourceManager, .Action, .Time) and
+	// the json function quotes a string (ex: {"text": {{ json .Message }}})
+	PayloadTemplate string `json:"payloadTemplate,omitempty"`
+	// Retries is the number of retries of a failed delivery, 3 when omitted
+	//+kubebuilder:validation:Minimum=0
+	Retries *int32 `json:"retries,omitempty"`
+}
+
+// SecretKeyReference references a key of a Secret
+type SecretKeyReference struct {
+	Name string `json:"name"`
+	// Namespace of the Secret, a ResourceManager references the Secrets of its own namespace only
+	Namespace string `json:"namespace,omitempty"`
+	// Key of the value in the Secret, "url" when omitted
+	Key string `json:"key,omitempty"`
+}
+
+// Condition types reported in the ResourceManager status
+const (
+	// ConditionReady is true when the objects are watched and their actions are scheduled
+	ConditionReady = "Ready"
+	// ConditionDegraded is true when the last action failed on some of the objects
+	ConditionDegraded = "Degraded"
+	// ConditionSpecInvalid is true when the spec cannot be handled
+	ConditionSpecInvalid = "SpecInvalid"
+)
+
+// Results of an action performed on a tracked object
+const (
+	ActionResultSucceeded = "Succeeded"
+	ActionResultFailed    = "Failed"
+	ActionResultDryRun    = "DryRun"
+)
+
+// TrackedObject describes a managed object and the action scheduled for it
+type TrackedObject struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+
+	// ExpiresAt is the time the action is due, empty when it cannot be calculated
+	ExpiresAt *metav1.Time `json:"expiresAt,omitempty"`
+
+	LastActionTime *metav1.Time `json:"lastActionTime,omitempty"`
+	// LastRunTime is the time the action was last performed on the object, it is kept across the occurrences of a recurring action
+	LastRunTime *metav1.Time `json:"lastRunTime,omitempty"`
+	// LastActionResult is one of Succeeded, Failed or DryRun
+	LastActionResult string `json:"lastActionResult,omitempty"`
+	// Message holds the error of the last action attempt or expiration calculation
+	Message string `json:"message,omitempty"`
+	// Override is the annotation of the object that overrides the expiration (ex: "expire-after=48h", "ignore=true")
+	Override string `json:"override,omitempty"`
+	// Extensions is the number of times the expiration was extended by the snooze annotations of the object
+	Extensions int32 `json:"extensions,omitempty"`
+	// Attempts is the number of times the last action was attempted, it is retried until it succeeds or the attempts are exhausted
+	Attempts int32 `json:"attempts,omitempty"`
 }
 
 // ResourceManagerStatus defines the observed state of ResourceManager
 type ResourceManagerStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions of the ResourceManager (Ready, Degraded, SpecInvalid)
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// MatchedObjects is the number of objects matching the selector
+	MatchedObjects int `json:"matchedObjects"`
+
+	// TrackedObjects lists the objects with the nearest expiration, it is bounded and may not include all matched objects
+	TrackedObjects []TrackedObject `json:"trackedObjects,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Kind",type=string,JSONPath=`.spec.resourceKind`
+//+kubebuilder:printcolumn:name="Action",type=string,JSONPath=`.spec.action`
+//+kubebuilder:printcolumn:name="Matched",type=integer,JSONPath=`.status.matchedObjects`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // ResourceManager is the Schema for the resourcemanagers API
 type ResourceManager struct {
@@ -0,0 +1,509 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var resourcemanagerlog = logf.Log.WithName("resourcemanager-resource")
+
+// Actions that can be performed on the managed objects
+const (
+	ActionDelete = "delete"
+	ActionPatch  = "patch"
+	ActionScale  = "scale"
+)
+
+// Patch types of the patch action
+const (
+	PatchTypeStrategic = "strategic"
+	PatchTypeMerge     = "merge"
+	PatchTypeJSON      = "json"
+	PatchTypeApply     = "apply"
+)
+
+// Policies applied to the managed objects when the resource manager is deleted
+const (
+	OnDeleteStop    = "stop"
+	OnDeleteExecute = "execute"
+	OnDeleteRevert  = "revert"
+)
+
+// Recurrences of the action
+const (
+	RecurrenceOnce  = "once"
+	RecurrenceEvery = "every"
+)
+
+// ExpireAtLayout is the time of day format of the 'at' expiration
+const ExpireAtLayout = "15:04"
+
+// wellKnownAPIVersions maps common built-in kinds to the apiVersion used when resourceApiVersion is omitted
+var wellKnownAPIVersions = map[string]string{
+	"Namespace":             "v1",
+	"Pod":                   "v1",
+	"ConfigMap":             "v1",
+	"Secret":                "v1",
+	"Service":               "v1",
+	"PersistentVolumeClaim": "v1",
+	"Deployment":            "apps/v1",
+	"StatefulSet":           "apps/v1",
+	"DaemonSet":             "apps/v1",
+	"ReplicaSet":            "apps/v1",
+	"Job":                   "batch/v1",
+	"CronJob":               "batch/v1",
+}
+
+// clusterScopedKinds are well known kinds that only a ClusterResourceManager may manage
+var clusterScopedKinds = map[string]bool{
+	"Namespace":                true,
+	"Node":                     true,
+	"PersistentVolume":         true,
+	"ClusterRole":              true,
+	"ClusterRoleBinding":       true,
+	"StorageClass":             true,
+	"CustomResourceDefinition": true,
+}
+
+// unscalableKinds are well known kinds that have no scale subresource, the scale action is rejected for them
+var unscalableKinds = map[string]bool{
+	"Namespace":             true,
+	"Pod":                   true,
+	"ConfigMap":             true,
+	"Secret":                true,
+	"Service":               true,
+	"PersistentVolumeClaim": true,
+	"DaemonSet":             true,
+	"Job":                   true,
+	"CronJob":               true,
+}
+
+func (r *ResourceManager) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-resource-management-tikalk-com-v1alpha1-resourcemanager,mutating=true,failurePolicy=fail,sideEffects=None,groups=resource-management.tikalk.com,resources=resourcemanagers,verbs=create;update,versions=v1alpha1,name=mresourcemanager.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Defaulter = &ResourceManager{}
+
+// Default implements webhook.Defaulter so a webhook will be registered for the type
+func (r *ResourceManager) Default() {
+	resourcemanagerlog.Info("default", "name", r.Name)
+
+	r.Spec.setDefaults()
+}
+
+// setDefaults sets the defaults of the fields that are omitted
+func (spec *ResourceManagerSpec) setDefaults() {
+	// pin the version of well known kinds, so it is visible which objects are managed
+	if spec.ResourceAPIVersion == "" {
+		spec.ResourceAPIVersion = wellKnownAPIVersions[spec.ResourceKind]
+	}
+	if spec.Action == ActionPatch && spec.PatchType == "" {
+		spec.PatchType = PatchTypeStrategic
+	}
+	if spec.OnDelete == "" {
+		spec.OnDelete = OnDeleteStop
+	}
+	if spec.Notifications != nil {
+		if spec.Notifications.SecretRef.Key == "" {
+			spec.Notifications.SecretRef.Key = "url"
+		}
+		if spec.Notifications.PayloadTemplate == "" {
+			spec.Notifications.PayloadTemplate = DefaultNotificationTemplate
+		}
+	}
+}
+
+//+kubebuilder:webhook:path=/validate-resource-management-tikalk-com-v1alpha1-resourcemanager,mutating=false,failurePolicy=fail,sideEffects=None,groups=resource-management.tikalk.com,resources=resourcemanagers,verbs=create;update,versions=v1alpha1,name=vresourcemanager.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &ResourceManager{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *ResourceManager) ValidateCreate() error {
+	resourcemanagerlog.Info("validate create", "name", r.Name)
+
+	return r.Validate()
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *ResourceManager) ValidateUpdate(old runtime.Object) error {
+	resourcemanagerlog.Info("validate update", "name", r.Name)
+
+	if oldResourceManager, ok := old.(*ResourceManager); ok && !validatesUpdate(r, &oldResourceManager.Spec, &r.Spec) {
+		return nil
+	}
+	return r.Validate()
+}
+
+// validatesUpdate returns false when the update keeps the spec (ex: the finalizer is added or removed) or the object is being deleted.
+// Such updates are not validated, so the objects whose spec fails the current rules can still be finalized and deleted.
+func validatesUpdate(obj metav1.Object, oldSpec *ResourceManagerSpec, spec *ResourceManagerSpec) bool {
+	return obj.GetDeletionTimestamp() == nil && !equality.Semantic.DeepEqual(oldSpec, spec)
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *ResourceManager) ValidateDelete() error {
+	resourcemanagerlog.Info("validate delete", "name", r.Name)
+
+	return nil
+}
+
+// Validate checks that the spec can be handled, the controller uses it as well for objects that bypassed the webhook.
+// A ResourceManager manages the namespaced objects of its own namespace only.
+func (r *ResourceManager) Validate() error {
+	path := field.NewPath("spec")
+	allErrs := r.Spec.validate(path)
+	if clusterScopedKinds[r.Spec.ResourceKind] {
+		allErrs = append(allErrs, field.Forbidden(path.Child("resourceKind"), "cluster-scoped kinds are managed by a ClusterResourceManager"))
+	}
+	if r.Spec.NamespaceSelector != nil {
+		allErrs = append(allErrs, field.Forbidden(path.Child("namespaceSelector"), "a ResourceManager manages the objects of its own namespace, use a ClusterResourceManager"))
+	}
+	if len(r.Spec.Namespaces) > 0 {
+		allErrs = append(allErrs, field.Forbidden(path.Child("namespaces"), "a ResourceManager manages the objects of its own namespace, use a ClusterResourceManager"))
+	}
+	if r.Spec.Notifications != nil && r.Spec.Notifications.SecretRef.Namespace != "" && r.Spec.Notifications.SecretRef.Namespace != r.Namespace {
+		allErrs = append(allErrs, field.Forbidden(path.Child("notifications", "secretRef", "namespace"), "a ResourceManager references the Secrets of its own namespace"))
+	}
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(schema.GroupKind{Group: GroupVersion.Group, Kind: "ResourceManager"}, r.Name, allErrs)
+}
+
+// validate returns the errors of all the invalid spec fields
+func (spec *ResourceManagerSpec) validate(path *field.Path) (allErrs field.ErrorList) {
+	if spec.ResourceKind == "" {
+		allErrs = append(allErrs, field.Required(path.Child("resourceKind"), "the kind of the managed objects is required"))
+	}
+	if spec.ResourceAPIVersion != "" {
+		if _, err := schema.ParseGroupVersion(spec.ResourceAPIVersion); err != nil {
+			allErrs = append(allErrs, field.Invalid(path.Child("resourceApiVersion"), spec.ResourceAPIVersion, err.Error()))
+		}
+	}
+
+	if spec.NamespaceSelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(spec.NamespaceSelector); err != nil {
+			allErrs = append(allErrs, field.Invalid(path.Child("namespaceSelector"), spec.NamespaceSelector, err.Error()))
+		}
+	}
+	for i, namespace := range spec.Namespaces {
+		for _, msg := range validation.IsDNS1123Label(namespace) {
+			allErrs = append(allErrs, field.Invalid(path.Child("namespaces").Index(i), namespace, msg))
+		}
+	}
+
+	if spec.Selector == nil {
+		allErrs = append(allErrs, field.Required(path.Child("selector"), "a selector is required, use an empty selector to match all objects"))
+	} else if _, err := metav1.LabelSelectorAsSelector(spec.Selector); err != nil {
+		allErrs = append(allErrs, field.Invalid(path.Child("selector"), spec.Selector, err.Error()))
+	}
+
+	switch spec.Action {
+	case ActionDelete:
+	case ActionScale:
+		// the kinds of other API groups (ex: a custom resource named Job) may have a scale subresource
+		if unscalableKinds[spec.ResourceKind] && (spec.ResourceAPIVersion == "" || spec.ResourceAPIVersion == wellKnownAPIVersions[spec.ResourceKind]) {
+			allErrs = append(allErrs, field.Forbidden(path.Child("action"), fmt.Sprintf("the %s kind has no scale subresource", spec.ResourceKind)))
+		}
+		if spec.Scale == nil {
+			allErrs = append(allErrs, field.Required(path.Child("scale"), "the replicas are required for the scale action"))
+		} else {
+			if spec.Scale.Replicas < 0 {
+				allErrs = append(allErrs, field.Invalid(path.Child("scale", "replicas"), spec.Scale.Replicas, "must not be negative"))
+			}
+			if spec.Scale.Restore != nil {
+				allErrs = append(allErrs, spec.Scale.Restore.validate(path.Child("scale", "restore"))...)
+				if spec.Scale.Restore.Window != nil {
+					allErrs = append(allErrs, field.Forbidden(path.Child("scale", "restore", "window"), "the objects are restored once"))
+				}
+				if spec.Condition.Window != nil {
+					allErrs = append(allErrs, field.Forbidden(path.Child("scale", "restore"), "the objects are restored when the window closes"))
+				}
+			}
+		}
+	case ActionPatch:
+		patchTypeErrs := spec.validatePatchType(path)
+		allErrs = append(allErrs, patchTypeErrs...)
+		if spec.ActionParam == "" {
+			allErrs = append(allErrs, field.Required(path.Child("actionParam"), "the patch is required for the patch action"))
+		} else if len(patchTypeErrs) == 0 {
+			allErrs = append(allErrs, spec.validatePatch(path.Child("actionParam"), spec.ActionParam)...)
+		}
+		if window := spec.Condition.Window; window != nil {
+			exitPath := path.Child("expiration", "window", "exitActionParam")
+			if window.ExitActionParam == "" {
+				allErrs = append(allErrs, field.Required(exitPath, "the exit patch is required for the patch action"))
+			} else if len(patchTypeErrs) == 0 {
+				allErrs = append(allErrs, spec.validatePatch(exitPath, window.ExitActionParam)...)
+			}
+		}
+	default:
+		allErrs = append(allErrs, field.NotSupported(path.Child("action"), spec.Action, []string{ActionDelete, ActionPatch, ActionScale}))
+	}
+	if spec.PatchType != "" && spec.Action != ActionPatch {
+		allErrs = append(allErrs, field.Forbidden(path.Child("patchType"), "applies to the patch action only"))
+	}
+	if spec.Scale != nil && spec.Action != ActionScale {
+		allErrs = append(allErrs, field.Forbidden(path.Child("scale"), "applies to the scale action only"))
+	}
+	if window := spec.Condition.Window; window != nil {
+		if spec.Action == ActionDelete {
+			allErrs = append(allErrs, field.Forbidden(path.Child("expiration", "window"), "the delete action cannot be undone when the window closes"))
+		}
+		if window.ExitActionParam != "" && spec.Action != ActionPatch {
+			allErrs = append(allErrs, field.Forbidden(path.Child("expiration", "window", "exitActionParam"), "applies to the patch action only"))
+		}
+	}
+
+	switch spec.OnDelete {
+	case "", OnDeleteStop, OnDeleteExecute:
+	case OnDeleteRevert:
+		if spec.Action == ActionDelete {
+			allErrs = append(allErrs, field.Forbidden(path.Child("onDelete"), "the delete action cannot be reverted"))
+		}
+	default:
+		allErrs = append(allErrs, field.NotSupported(path.Child("onDelete"), spec.OnDelete, []string{OnDeleteStop, OnDeleteExecute, OnDeleteRevert}))
+	}
+
+	allErrs = append(allErrs, spec.Condition.validate(path.Child("expiration"))...)
+	switch spec.Recurrence {
+	case "", RecurrenceOnce:
+	case RecurrenceEvery:
+		if spec.Action == ActionDelete {
+			allErrs = append(allErrs, field.Forbidden(path.Child("recurrence"), "the delete action cannot recur"))
+		}
+		if spec.Condition.ExpireAfter != "" {
+			allErrs = append(allErrs, field.Forbidden(path.Child("recurrence"), "the 'after' expiration occurs once"))
+		}
+	default:
+		allErrs = append(allErrs, field.NotSupported(path.Child("recurrence"), spec.Recurrence, []string{RecurrenceOnce, RecurrenceEvery}))
+	}
+	for i, warnBefore := range spec.WarnBefore {
+		if d, err := time.ParseDuration(warnBefore); err != nil {
+			allErrs = append(allErrs, field.Invalid(path.Child("warnBefore").Index(i), warnBefore, err.Error()))
+		} else if d <= 0 {
+			allErrs = append(allErrs, field.Invalid(path.Child("warnBefore").Index(i), warnBefore, "must be positive"))
+		}
+	}
+	if spec.Lease != nil {
+		allErrs = append(allErrs, spec.Lease.validate(path.Child("lease"))...)
+	}
+	if spec.Retry != nil {
+		allErrs = append(allErrs, spec.Retry.validate(path.Child("retry"))...)
+	}
+	if spec.Notifications != nil {
+		allErrs = append(allErrs, spec.Notifications.validate(path.Child("notifications"))...)
+	}
+	return allErrs
+}
+
+// defaultRecurrence returns the recurrence of an omitted recurrence: the actions that keep the objects recur with 'at' and 'schedule'
+func (spec *ResourceManagerSpec) defaultRecurrence() string {
+	if spec.Action != ActionDelete && spec.Condition.Recurs() {
+		return RecurrenceEvery
+	}
+	return RecurrenceOnce
+}
+
+// Recurs returns true when the action is performed on every occurrence of the expiration, an omitted recurrence is defaulted
+func (spec *ResourceManagerSpec) Recurs() bool {
+	if spec.Recurrence == "" {
+		return spec.defaultRecurrence() == RecurrenceEvery
+	}
+	return spec.Recurrence == RecurrenceEvery
+}
+
+// ParseNotificationTemplate parses the payload template of the notifications, an empty template is the default one
+func ParseNotificationTemplate(payloadTemplate string) (*template.Template, error) {
+	if payloadTemplate == "" {
+		payloadTemplate = DefaultNotificationTemplate
+	}
+	return template.New("payloadTemplate").Option("missingkey=error").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(payloadTemplate)
+}
+
+// validate validates the webhook of the notifications
+func (n *Notifications) validate(path *field.Path) (allErrs field.ErrorList) {
+	if n.SecretRef.Name == "" {
+		allErrs = append(allErrs, field.Required(path.Child("secretRef", "name"), "the Secret holding the webhook URL is required"))
+	}
+	if _, err := ParseNotificationTemplate(n.PayloadTemplate); err != nil {
+		allErrs = append(allErrs, field.Invalid(path.Child("payloadTemplate"), n.PayloadTemplate, err.Error()))
+	}
+	if n.Retries != nil && *n.Retries < 0 {
+		allErrs = append(allErrs, field.Invalid(path.Child("retries"), *n.Retries, "must not be negative"))
+	}
+	return allErrs
+}
+
+// validate validates the attempts and the delays of the retries
+func (p *RetryPolicy) validate(path *field.Path) (allErrs field.ErrorList) {
+	if p.MaxAttempts != nil && *p.MaxAttempts < 1 {
+		allErrs = append(allErrs, field.Invalid(path.Child("maxAttempts"), *p.MaxAttempts, "must be at least 1"))
+	}
+	for _, delay := range []struct{ name, value string }{{"backoff", p.Backoff}, {"maxBackoff", p.MaxBackoff}} {
+		if delay.value == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(delay.value); err != nil {
+			allErrs = append(allErrs, field.Invalid(path.Child(delay.name), delay.value, err.Error()))
+		} else if d <= 0 {
+			allErrs = append(allErrs, field.Invalid(path.Child(delay.name), delay.value, "must be positive"))
+		}
+	}
+	return allErrs
+}
+
+// validate validates the limits of the lease extensions
+func (l *LeasePolicy) validate(path *field.Path) (allErrs field.ErrorList) {
+	if l.MaxExtensions != nil && *l.MaxExtensions < 0 {
+		allErrs = append(allErrs, field.Invalid(path.Child("maxExtensions"), *l.MaxExtensions, "must not be negative"))
+	}
+	if l.MaxLifetime != "" {
+		if d, err := time.ParseDuration(l.MaxLifetime); err != nil {
+			allErrs = append(allErrs, field.Invalid(path.Child("maxLifetime"), l.MaxLifetime, err.Error()))
+		} else if d <= 0 {
+			allErrs = append(allErrs, field.Invalid(path.Child("maxLifetime"), l.MaxLifetime, "must be positive"))
+		}
+	}
+	return allErrs
+}
+
+// ParseActionParam parses the action param as a template, missing keys fail the rendering
+func ParseActionParam(actionParam string) (*template.Template, error) {
+	return template.New("actionParam").Option("missingkey=error").Parse(actionParam)
+}
+
+// validatePatchType checks that the patch type of the patch action is supported
+func (spec *ResourceManagerSpec) validatePatchType(path *field.Path) (allErrs field.ErrorList) {
+	switch spec.PatchType {
+	case "", PatchTypeStrategic, PatchTypeMerge, PatchTypeJSON, PatchTypeApply:
+	default:
+		allErrs = append(allErrs, field.NotSupported(path.Child("patchType"), spec.PatchType, []string{PatchTypeStrategic, PatchTypeMerge, PatchTypeJSON, PatchTypeApply}))
+	}
+	return allErrs
+}
+
+// validatePatch checks that a patch (ex: the action param) is a valid JSON of the patch type, path is the path of the patch
+func (spec *ResourceManagerSpec) validatePatch(path *field.Path, patch string) (allErrs field.ErrorList) {
+	// a template is a valid JSON only once rendered, so it is checked when the action is performed
+	if strings.Contains(patch, "{{") {
+		if _, err := ParseActionParam(patch); err != nil {
+			allErrs = append(allErrs, field.Invalid(path, patch, err.Error()))
+		}
+		return allErrs
+	}
+
+	if spec.PatchType == PatchTypeJSON {
+		var operations []map[string]interface{}
+		if err := json.Unmarshal([]byte(patch), &operations); err != nil {
+			allErrs = append(allErrs, field.Invalid(path, patch, "the JSON patch is not a valid list of operations"))
+		}
+	} else {
+		var object map[string]interface{}
+		if err := json.Unmarshal([]byte(patch), &object); err != nil {
+			allErrs = append(allErrs, field.Invalid(path, patch, "the patch is not a valid JSON object"))
+		}
+	}
+	return allErrs
+}
+
+// Recurs returns true when the expiration has a next occurrence, the 'after' expiration occurs once
+func (e *Expiration) Recurs() bool {
+	return e.ExpireAt != "" || e.Schedule != "" || e.Window != nil
+}
+
+// validate checks that exactly one valid expiration is configured
+func (e *Expiration) validate(path *field.Path) (allErrs field.ErrorList) {
+	configured := 0
+	for _, value := range []string{e.ExpireAfter, e.ExpireAt, e.Schedule} {
+		if value != "" {
+			configured++
+		}
+	}
+	if e.Window != nil {
+		configured++
+	}
+	switch {
+	case configured == 0:
+		allErrs = append(allErrs, field.Required(path, "one of 'after', 'at', 'schedule' or 'window' is required"))
+	case configured > 1:
+		allErrs = append(allErrs, field.Forbidden(path, "only one of 'after', 'at', 'schedule' or 'window' may be set"))
+	}
+
+	if e.ExpireAfter != "" {
+		if after, err := time.ParseDuration(e.ExpireAfter); err != nil {
+			allErrs = append(allErrs, field.Invalid(path.Child("after"), e.ExpireAfter, err.Error()))
+		} else if after < 0 {
+			allErrs = append(allErrs, field.Invalid(path.Child("after"), e.ExpireAfter, "must not be negative"))
+		}
+	}
+	if e.ExpireAt != "" {
+		if _, err := time.Parse(ExpireAtLayout, e.ExpireAt); err != nil {
+			allErrs = append(allErrs, field.Invalid(path.Child("at"), e.ExpireAt, fmt.Sprintf("must be a time of day in the %q format", ExpireAtLayout)))
+		}
+	}
+	if e.Schedule != "" {
+		if _, err := cron.ParseStandard(e.Schedule); err != nil {
+			allErrs = append(allErrs, field.Invalid(path.Child("schedule"), e.Schedule, err.Error()))
+		}
+	}
+	if e.Window != nil {
+		for _, schedule := range []struct{ name, value string }{{"start", e.Window.Start}, {"end", e.Window.End}} {
+			if schedule.value == "" {
+				allErrs = append(allErrs, field.Required(path.Child("window", schedule.name), "a cron schedule is required"))
+			} else if _, err := cron.ParseStandard(schedule.value); err != nil {
+				allErrs = append(allErrs, field.Invalid(path.Child("window", schedule.name), schedule.value, err.Error()))
+			}
+		}
+	}
+	if e.TimeZone != "" {
+		if e.ExpireAfter != "" {
+			allErrs = append(allErrs, field.Forbidden(path.Child("timeZone"), "a time zone applies to 'at', 'schedule' and 'window' only"))
+		}
+		if _, err := e.Location(); err != nil {
+			allErrs = append(allErrs, field.Invalid(path.Child("timeZone"), e.TimeZone, err.Error()))
+		}
+	}
+	return allErrs
+}
@@ -0,0 +1,278 @@
+package v1alpha1
+
+import (
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// newResourceManager returns a valid resource manager to be modified by the tests
+func newResourceManager(name string) *ResourceManager {
+	return &ResourceManager{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+		},
+		Spec: ResourceManagerSpec{
+			ResourceKind: "Deployment",
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					"app": "nginx",
+				},
+			},
+			Action: ActionDelete,
+			Condition: Expiration{
+				ExpireAfter: "1h",
+			},
+		},
+	}
+}
+
+var _ = Context("ResourceManager webhooks", func() {
+	Describe("defaulting", func() {
+		It("should set the apiVersion of a well known kind", func() {
+			resourceManager := newResourceManager("test-defaulting")
+			Expect(k8sClient.Create(ctx, resourceManager)).To(Succeed())
+			Expect(resourceManager.Spec.ResourceAPIVersion).To(Equal("apps/v1"))
+		})
+
+		It("should set the patch type of the patch action", func() {
+			resourceManager := newResourceManager("test-defaulting-patch")
+			resourceManager.Spec.Action = ActionPatch
+			resourceManager.Spec.ActionParam = `{"metadata":{"labels":{"expired":"true"}}}`
+			Expect(k8sClient.Create(ctx, resourceManager)).To(Succeed())
+			Expect(resourceManager.Spec.PatchType).To(Equal(PatchTypeStrategic))
+		})
+
+		It("should stop tracking the objects on deletion", func() {
+			resourceManager := newResourceManager("test-defaulting-on-delete")
+			Expect(k8sClient.Create(ctx, resourceManager)).To(Succeed())
+			Expect(resourceManager.Spec.OnDelete).To(Equal(OnDeleteStop))
+		})
+
+		It("should repeat a patch on every occurrence of its time of day", func() {
+			resourceManager := newResourceManager("test-defaulting-recurrence")
+			resourceManager.Spec.Action = ActionPatch
+			resourceManager.Spec.ActionParam = `{"metadata":{"labels":{"expired":"true"}}}`
+			resourceManager.Spec.Condition = Expiration{ExpireAt: "20:00"}
+			Expect(k8sClient.Create(ctx, resourceManager)).To(Succeed())
+			Expect(resourceManager.Spec.Recurrence).To(BeEmpty())
+			Expect(resourceManager.Spec.Recurs()).To(BeTrue())
+
+			// the omitted recurrence follows the updates of the action
+			resourceManager.Spec.Action = ActionDelete
+			resourceManager.Spec.ActionParam = ""
+			resourceManager.Spec.PatchType = ""
+			Expect(k8sClient.Update(ctx, resourceManager)).To(Succeed())
+			Expect(resourceManager.Spec.Recurs()).To(BeFalse())
+		})
+
+		It("should repeat a window on every occurrence", func() {
+			resourceManager := newResourceManager("test-defaulting-recurrence-window")
+			resourceManager.Spec.Action = ActionScale
+			resourceManager.Spec.Scale = &ScaleAction{Replicas: 0}
+			resourceManager.Spec.Condition = Expiration{Window: &Window{Start: "0 20 * * 1-5", End: "0 7 * * 1-5"}}
+			Expect(k8sClient.Create(ctx, resourceManager)).To(Succeed())
+			Expect(resourceManager.Spec.Recurs()).To(BeTrue())
+		})
+
+		It("should delete an object once", func() {
+			resourceManager := newResourceManager("test-defaulting-recurrence-delete")
+			resourceManager.Spec.Condition = Expiration{ExpireAt: "20:00"}
+			Expect(k8sClient.Create(ctx, resourceManager)).To(Succeed())
+			Expect(resourceManager.Spec.Recurs()).To(BeFalse())
+		})
+	})
+
+	Describe("validation", func() {
+		It("should accept a valid spec", func() {
+			resourceManager := newResourceManager("test-valid")
+			resourceManager.Spec.Action = ActionPatch
+			resourceManager.Spec.ActionParam = `{"metadata":{"labels":{"expired":"true"}}}`
+			resourceManager.Spec.Condition = Expiration{ExpireAt: "20:30"}
+			Expect(k8sClient.Create(ctx, resourceManager)).To(Succeed())
+		})
+
+		It("should accept a patch window", func() {
+			resourceManager := newResourceManager("test-valid-window")
+			resourceManager.Spec.Action = ActionPatch
+			resourceManager.Spec.ActionParam = `{"metadata":{"labels":{"sleeping":"true"}}}`
+			resourceManager.Spec.Condition = Expiration{
+				Window: &Window{
+					Start:           "0 20 * * 1-5",
+					End:             "0 7 * * 1-5",
+					ExitActionParam: `{"metadata":{"labels":{"sleeping":"false"}}}`,
+				},
+				TimeZone: "Asia/Jerusalem",
+			}
+			Expect(k8sClient.Create(ctx, resourceManager)).To(Succeed())
+		})
+
+		It("should accept a JSON patch", func() {
+			resourceManager := newResourceManager("test-valid-json-patch")
+			resourceManager.Spec.Action = ActionPatch
+			resourceManager.Spec.PatchType = PatchTypeJSON
+			resourceManager.Spec.ActionParam = `[{"op":"remove","path":"/metadata/labels/owner"}]`
+			Expect(k8sClient.Create(ctx, resourceManager)).To(Succeed())
+		})
+
+		It("should accept the updates that keep an invalid spec", func() {
+			old := newResourceManager("test-invalid-kept")
+			old.Spec.ResourceKind = "Namespace"
+			Expect(old.Validate()).NotTo(Succeed())
+
+			// ex: the finalizer of a resource manager created before the webhook is added, and removed on deletion
+			updated := old.DeepCopy()
+			updated.Finalizers = []string{"resource-management.tikalk.com/finalizer"}
+			Expect(updated.ValidateUpdate(old)).To(Succeed())
+			deleted := old.DeepCopy()
+			now := metav1.Now()
+			deleted.DeletionTimestamp = &now
+			Expect(deleted.ValidateUpdate(old)).To(Succeed())
+
+			// the spec updates are validated
+			changed := old.DeepCopy()
+			changed.Spec.Condition.ExpireAfter = "2h"
+			Expect(changed.ValidateUpdate(old)).NotTo(Succeed())
+		})
+
+		table.DescribeTable("should reject an invalid spec",
+			func(name string, mutate func(spec *ResourceManagerSpec)) {
+				resourceManager := newResourceManager(name)
+				mutate(&resourceManager.Spec)
+				Expect(k8sClient.Create(ctx, resourceManager)).NotTo(Succeed())
+			},
+			table.Entry("unparsable after", "test-invalid-after", func(spec *ResourceManagerSpec) {
+				spec.Condition.ExpireAfter = "tomorrow"
+			}),
+			table.Entry("at in a wrong format", "test-invalid-at", func(spec *ResourceManagerSpec) {
+				spec.Condition = Expiration{ExpireAt: "8pm"}
+			}),
+			table.Entry("both after and at", "test-invalid-expiration", func(spec *ResourceManagerSpec) {
+				spec.Condition.ExpireAt = "20:00"
+			}),
+			table.Entry("unparsable schedule", "test-invalid-schedule", func(spec *ResourceManagerSpec) {
+				spec.Condition = Expiration{Schedule: "every weekday"}
+			}),
+			table.Entry("both after and schedule", "test-invalid-schedule-after", func(spec *ResourceManagerSpec) {
+				spec.Condition.Schedule = "0 20 * * 1-5"
+			}),
+			table.Entry("unknown time zone", "test-invalid-time-zone", func(spec *ResourceManagerSpec) {
+				spec.Condition = Expiration{ExpireAt: "20:00", TimeZone: "Asia/Atlantis"}
+			}),
+			table.Entry("time zone with after", "test-invalid-time-zone-after", func(spec *ResourceManagerSpec) {
+				spec.Condition.TimeZone = "Asia/Jerusalem"
+			}),
+			table.Entry("scale without replicas", "test-invalid-scale", func(spec *ResourceManagerSpec) {
+				spec.Action = ActionScale
+			}),
+			table.Entry("scale of ConfigMaps", "test-invalid-scale-configmap", func(spec *ResourceManagerSpec) {
+				spec.ResourceKind = "ConfigMap"
+				spec.Action = ActionScale
+				spec.Scale = &ScaleAction{Replicas: 0}
+			}),
+			table.Entry("scale of Secrets", "test-invalid-scale-secret", func(spec *ResourceManagerSpec) {
+				spec.ResourceKind = "Secret"
+				spec.ResourceAPIVersion = "v1"
+				spec.Action = ActionScale
+				spec.Scale = &ScaleAction{Replicas: 0}
+			}),
+			table.Entry("invalid restore", "test-invalid-restore", func(spec *ResourceManagerSpec) {
+				spec.Action = ActionScale
+				spec.Scale = &ScaleAction{Replicas: 0, Restore: &Expiration{ExpireAt: "8am"}}
+			}),
+			table.Entry("cluster-scoped kind", "test-invalid-cluster-scoped", func(spec *ResourceManagerSpec) {
+				spec.ResourceKind = "Namespace"
+			}),
+			table.Entry("namespace selector", "test-invalid-namespace-selector", func(spec *ResourceManagerSpec) {
+				spec.NamespaceSelector = &metav1.LabelSelector{}
+			}),
+			table.Entry("namespaces", "test-invalid-namespaces", func(spec *ResourceManagerSpec) {
+				spec.Namespaces = []string{"team-a"}
+			}),
+			table.Entry("unparsable lease lifetime", "test-invalid-lease-lifetime", func(spec *ResourceManagerSpec) {
+				spec.Lease = &LeasePolicy{MaxLifetime: "a week"}
+			}),
+			table.Entry("unparsable warning lead time", "test-invalid-warn-before", func(spec *ResourceManagerSpec) {
+				spec.WarnBefore = []string{"1h", "soon"}
+			}),
+			table.Entry("notifications without a secret", "test-invalid-notifications", func(spec *ResourceManagerSpec) {
+				spec.Notifications = &Notifications{}
+			}),
+			table.Entry("notifications with an invalid template", "test-invalid-notifications-template", func(spec *ResourceManagerSpec) {
+				spec.Notifications = &Notifications{SecretRef: SecretKeyReference{Name: "webhook"}, PayloadTemplate: `{"text": {{ json .Message }`}
+			}),
+			table.Entry("notifications secret of another namespace", "test-invalid-notifications-namespace", func(spec *ResourceManagerSpec) {
+				spec.Notifications = &Notifications{SecretRef: SecretKeyReference{Name: "webhook", Namespace: "kube-system"}}
+			}),
+			table.Entry("retry without attempts", "test-invalid-retry-attempts", func(spec *ResourceManagerSpec) {
+				maxAttempts := int32(0)
+				spec.Retry = &RetryPolicy{MaxAttempts: &maxAttempts}
+			}),
+			table.Entry("unparsable retry backoff", "test-invalid-retry-backoff", func(spec *ResourceManagerSpec) {
+				spec.Retry = &RetryPolicy{Backoff: "a minute"}
+			}),
+			table.Entry("unknown action", "test-invalid-action", func(spec *ResourceManagerSpec) {
+				spec.Action = "archive"
+			}),
+			table.Entry("unknown deletion policy", "test-invalid-on-delete", func(spec *ResourceManagerSpec) {
+				spec.OnDelete = "archive"
+			}),
+			table.Entry("reverting the delete action", "test-invalid-on-delete-revert", func(spec *ResourceManagerSpec) {
+				spec.OnDelete = OnDeleteRevert
+			}),
+			table.Entry("window of the delete action", "test-invalid-window-delete", func(spec *ResourceManagerSpec) {
+				spec.Condition = Expiration{Window: &Window{Start: "0 20 * * *", End: "0 7 * * *"}}
+			}),
+			table.Entry("window with an invalid start", "test-invalid-window-start", func(spec *ResourceManagerSpec) {
+				spec.Action = ActionScale
+				spec.Scale = &ScaleAction{Replicas: 0}
+				spec.Condition = Expiration{Window: &Window{Start: "every evening", End: "0 7 * * *"}}
+			}),
+			table.Entry("window of a patch without an exit patch", "test-invalid-window-exit", func(spec *ResourceManagerSpec) {
+				spec.Action = ActionPatch
+				spec.ActionParam = `{"metadata":{"labels":{"sleeping":"true"}}}`
+				spec.Condition = Expiration{Window: &Window{Start: "0 20 * * *", End: "0 7 * * *"}}
+			}),
+			table.Entry("window of a scale with a restore", "test-invalid-window-restore", func(spec *ResourceManagerSpec) {
+				spec.Action = ActionScale
+				spec.Scale = &ScaleAction{Replicas: 0, Restore: &Expiration{ExpireAfter: "1h"}}
+				spec.Condition = Expiration{Window: &Window{Start: "0 20 * * *", End: "0 7 * * *"}}
+			}),
+			table.Entry("window with another expiration", "test-invalid-window-at", func(spec *ResourceManagerSpec) {
+				spec.Action = ActionScale
+				spec.Scale = &ScaleAction{Replicas: 0}
+				spec.Condition = Expiration{ExpireAt: "20:00", Window: &Window{Start: "0 20 * * *", End: "0 7 * * *"}}
+			}),
+			table.Entry("unknown recurrence", "test-invalid-recurrence", func(spec *ResourceManagerSpec) {
+				spec.Recurrence = "daily"
+			}),
+			table.Entry("recurring delete action", "test-invalid-recurrence-delete", func(spec *ResourceManagerSpec) {
+				spec.Condition = Expiration{ExpireAt: "20:00"}
+				spec.Recurrence = RecurrenceEvery
+			}),
+			table.Entry("recurring age expiration", "test-invalid-recurrence-after", func(spec *ResourceManagerSpec) {
+				spec.Action = ActionPatch
+				spec.ActionParam = `{"metadata":{"labels":{"expired":"true"}}}`
+				spec.Recurrence = RecurrenceEvery
+			}),
+			table.Entry("missing selector", "test-invalid-selector", func(spec *ResourceManagerSpec) {
+				spec.Selector = nil
+			}),
+			table.Entry("patch with invalid JSON", "test-invalid-patch", func(spec *ResourceManagerSpec) {
+				spec.Action = ActionPatch
+				spec.ActionParam = `{"metadata":`
+			}),
+			table.Entry("patch with an invalid template", "test-invalid-template", func(spec *ResourceManagerSpec) {
+				spec.Action = ActionPatch
+				spec.ActionParam = `{"metadata":{"labels":{"expired-by":"{{ .ResourceManager "}}}}`
+			}),
+			table.Entry("JSON patch that is not a list", "test-invalid-json-patch", func(spec *ResourceManagerSpec) {
+				spec.Action = ActionPatch
+				spec.PatchType = PatchTypeJSON
+				spec.ActionParam = `{"metadata":{"labels":{"expired":"true"}}}`
+			}),
+		)
+	})
+})
@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/tikalk/resource-manager/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/meta"
+)
+
+// actionParamData is the data the action param template is rendered with
+type actionParamData struct {
+	// Name is the name of the object
+	Name string
+	// Namespace is the namespace of the object, empty for cluster-scoped objects
+	Namespace string
+	// Labels are the labels of the object
+	Labels map[string]string
+	// CreationTimestamp is the creation time of the object
+	CreationTimestamp time.Time
+	// ResourceManager is the name of the resource manager
+	ResourceManager string
+	// Now is the time the action is performed
+	Now time.Time
+}
+
+// renderActionParam renders an action param template (ex: the patch of the action) against the object.
+// A template that references a missing field or label fails, so a partial patch is never applied.
+func (h *ObjectHandler) renderActionParam(actionParam string, now time.Time) ([]byte, error) {
+	tmpl, err := v1alpha1.ParseActionParam(actionParam)
+	if err != nil {
+		return nil, fmt.Errorf("actionParam: invalid template: %w", err)
+	}
+
+	accessor, err := meta.Accessor(h.getObject())
+	if err != nil {
+		return nil, err
+	}
+	labels := accessor.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	data := actionParamData{
+		Name:              accessor.GetName(),
+		Namespace:         accessor.GetNamespace(),
+		Labels:            labels,
+		CreationTimestamp: h.creationTime,
+		ResourceManager:   h.getResourceManager().GetName(),
+		Now:               now,
+	}
+
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, data); err != nil {
+		return nil, fmt.Errorf("actionParam: rendering failed: %w", err)
+	}
+	return rendered.Bytes(), nil
+}
@@ -0,0 +1,46 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	resourcemanagmentv1alpha1 "github.com/tikalk/resource-manager/api/v1alpha1"
+)
+
+//+kubebuilder:rbac:groups=resource-management.tikalk.com,resources=clusterresourcemanagers,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=resource-management.tikalk.com,resources=clusterresourcemanagers/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=resource-management.tikalk.com,resources=clusterresourcemanagers/finalizers,verbs=update
+
+// ClusterResourceManagerReconciler reconciles a ClusterResourceManager object.
+// It shares the handlers machinery of the ResourceManagerReconciler.
+type ClusterResourceManagerReconciler struct {
+	ResourceManagerReconciler
+}
+
+// Reconcile is responsible for enforcing the desired CR state on the actual state of the system.
+// It runs each time an event occurs on a watched CR or resource
+func (r *ClusterResourceManagerReconciler) Reconcile(ctx context.Context, request ctrl.Request) (ctrl.Result, error) {
+	return r.reconcile(ctx, request, &resourcemanagmentv1alpha1.ClusterResourceManager{})
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ClusterResourceManagerReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return r.setupWithManager(mgr, &resourcemanagmentv1alpha1.ClusterResourceManager{}, r)
+}
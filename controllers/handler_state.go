@@ -0,0 +1,28 @@
+package controllers
+
+// handlerState is the lifecycle state of a resource manager handler or of an object handler.
+// A handler is created, runs once and stops once, a stopped handler is never restarted.
+type handlerState int
+
+const (
+	// handlerCreated is the state of a handler that was not started yet
+	handlerCreated handlerState = iota
+	// handlerRunning is the state of a handler that handles its objects
+	handlerRunning
+	// handlerStopped is the state of a stopped handler, it schedules no tasks and registers no object handlers
+	handlerStopped
+)
+
+// String implements fmt.Stringer
+func (s handlerState) String() string {
+	switch s {
+	case handlerCreated:
+		return "created"
+	case handlerRunning:
+		return "running"
+	case handlerStopped:
+		return "stopped"
+	default:
+		return "unknown"
+	}
+}
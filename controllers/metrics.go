@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tikalk/resource-manager/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// actionRestore is the action label of the scale restore and of the window exit
+const actionRestore = "restore"
+
+var (
+	// actionsTotal counts the actions performed on the managed objects by their result
+	actionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "resourcemanager_actions_total",
+		Help: "Number of actions performed on the managed objects by resource manager, action and result",
+	}, []string{"rm", "action", "result"})
+
+	// actionRetries counts the retries of the failed actions
+	actionRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "resourcemanager_action_retries_total",
+		Help: "Number of retries of the failed actions by resource manager and action",
+	}, []string{"rm", "action"})
+
+	// actionDuration observes the time an action takes, including the API requests it makes
+	actionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "resourcemanager_action_duration_seconds",
+		Help:    "Duration of the actions performed on the managed objects",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"rm", "action"})
+
+	trackedObjectsDesc = prometheus.NewDesc(
+		"resourcemanager_tracked_objects",
+		"Number of objects matched by the resource manager",
+		[]string{"rm"}, nil)
+
+	nextActionDesc = prometheus.NewDesc(
+		"resourcemanager_next_action_seconds",
+		"Seconds until the next pending action of the resource manager, 0 when it is overdue",
+		[]string{"rm"}, nil)
+
+	// handlerMetrics reports the state of the running handlers when the metrics are scraped
+	handlerMetrics = &handlersCollector{handlers: make(map[string]*ResourceManagerHandler)}
+)
+
+func init() {
+	metrics.Registry.MustRegister(actionsTotal, actionRetries, actionDuration, handlerMetrics)
+}
+
+// resourceManagerLabel returns the rm label of a resource manager, "namespace/name" or "name" for a cluster resource manager
+func resourceManagerLabel(key types.NamespacedName) string {
+	if key.Namespace == "" {
+		return key.Name
+	}
+	return key.String()
+}
+
+// deleteActionMetrics removes the action metrics of a deleted resource manager
+func deleteActionMetrics(rm string) {
+	for _, action := range []string{v1alpha1.ActionDelete, v1alpha1.ActionPatch, v1alpha1.ActionScale, actionRestore, actionRevert} {
+		for _, result := range []string{v1alpha1.ActionResultSucceeded, v1alpha1.ActionResultFailed, v1alpha1.ActionResultDryRun} {
+			actionsTotal.DeleteLabelValues(rm, action, result)
+		}
+		actionRetries.DeleteLabelValues(rm, action)
+		actionDuration.DeleteLabelValues(rm, action)
+	}
+}
+
+// observeAction records the duration and the result of an action performed on the object
+func (h *ObjectHandler) observeAction(action string, start time.Time, err error) {
+	rm := resourceManagerLabel(client.ObjectKeyFromObject(h.getResourceManager()))
+	actionDuration.WithLabelValues(rm, action).Observe(time.Since(start).Seconds())
+	result := v1alpha1.ActionResultSucceeded
+	if err != nil {
+		result = v1alpha1.ActionResultFailed
+	}
+	actionsTotal.WithLabelValues(rm, action, result).Inc()
+}
+
+// countAction counts an action that was not performed (ex: dry-run)
+func (h *ObjectHandler) countAction(action string, result string) {
+	actionsTotal.WithLabelValues(resourceManagerLabel(client.ObjectKeyFromObject(h.getResourceManager())), action, result).Inc()
+}
+
+// handlersCollector collects the gauges of the running handlers, so the time until the next action is current on every scrape
+type handlersCollector struct {
+	lock     sync.Mutex
+	handlers map[string]*ResourceManagerHandler
+}
+
+// set registers the running handler of the resource manager, a nil handler removes it
+func (c *handlersCollector) set(rm string, handler *ResourceManagerHandler) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if handler == nil {
+		delete(c.handlers, rm)
+	} else {
+		c.handlers[rm] = handler
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *handlersCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- trackedObjectsDesc
+	ch <- nextActionDesc
+}
+
+// Collect implements prometheus.Collector
+func (c *handlersCollector) Collect(ch chan<- prometheus.Metric) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := time.Now()
+	for rm, handler := range c.handlers {
+		matched, nextActionAt := handler.nextAction()
+		ch <- prometheus.MustNewConstMetric(trackedObjectsDesc, prometheus.GaugeValue, float64(matched), rm)
+		if !nextActionAt.IsZero() {
+			seconds := nextActionAt.Sub(now).Seconds()
+			if seconds < 0 {
+				seconds = 0
+			}
+			ch <- prometheus.MustNewConstMetric(nextActionDesc, prometheus.GaugeValue, seconds, rm)
+		}
+	}
+}
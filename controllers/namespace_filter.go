@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"github.com/tikalk/resource-manager/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+// namespacesResource is the resource of the namespaces, watched to select namespaces by their labels
+var namespacesResource = schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}
+
+// namespaceFilter matches the namespaces listed by name or selected by labels in the resource manager spec
+type namespaceFilter struct {
+	names map[string]bool
+	// selected holds the namespaces that match the namespace selector, nil when there is no selector
+	selected cache.SharedIndexInformer
+}
+
+// newNamespaceFilter returns the namespace filter of the resource manager, or nil when the objects of all namespaces are managed
+func newNamespaceFilter(resourceManager v1alpha1.ResourceManagerObject, dynamicClient dynamic.Interface) (*namespaceFilter, error) {
+	spec := resourceManager.GetSpec()
+	if spec.NamespaceSelector == nil && len(spec.Namespaces) == 0 {
+		return nil, nil
+	}
+
+	filter := &namespaceFilter{names: make(map[string]bool, len(spec.Namespaces))}
+	for _, name := range spec.Namespaces {
+		filter.names[name] = true
+	}
+	if spec.NamespaceSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(spec.NamespaceSelector)
+		if err != nil {
+			return nil, err
+		}
+		// the watch reports a namespace that stops matching the selector as deleted
+		filter.selected = dynamicinformer.NewFilteredDynamicInformer(dynamicClient, namespacesResource, metav1.NamespaceAll, 0, cache.Indexers{}, func(opts *metav1.ListOptions) {
+			opts.LabelSelector = selector.String()
+		}).Informer()
+	}
+	return filter, nil
+}
+
+// Matches returns true when the objects in the namespace are managed
+func (f *namespaceFilter) Matches(namespace string) bool {
+	if f.names[namespace] {
+		return true
+	}
+	if f.selected == nil {
+		return false
+	}
+	_, exists, err := f.selected.GetStore().GetByKey(namespace)
+	return err == nil && exists
+}
+
+// Run watches the selected namespaces until stopped, onChange is called with the namespaces that may have changed
+func (f *namespaceFilter) Run(stopper <-chan struct{}, onChange func(namespace string)) {
+	if f.selected == nil {
+		return
+	}
+	notify := func(obj interface{}) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		if fullname, err := extractFullname(obj); err == nil {
+			onChange(fullname.Name)
+		}
+	}
+	f.selected.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    notify,
+		DeleteFunc: notify,
+	})
+	go f.selected.Run(stopper)
+}
+
+// HasSynced returns true once the selected namespaces are listed
+func (f *namespaceFilter) HasSynced() bool {
+	return f.selected == nil || f.selected.HasSynced()
+}
@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"text/template"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/tikalk/resource-manager/api/v1alpha1"
+	"github.com/tikalk/resource-manager/controllers/notifier"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+)
+
+// notificationData is the data of the payload template
+type notificationData struct {
+	// Reason is the reason of the event (ex: Expiring, ActionSucceeded)
+	Reason  string
+	Message string
+	// Kind is the kind of the object and Object its full name
+	Kind      string
+	Object    string
+	Name      string
+	Namespace string
+	// ResourceManager is the name of the resource manager
+	ResourceManager string
+	Action          string
+	Time            time.Time
+}
+
+// notificationSender renders the notifications of a resource manager and passes them to the notifier
+type notificationSender struct {
+	notifications *v1alpha1.Notifications
+	template      *template.Template
+	// secrets reads the Secret holding the webhook URL, it is read by the notifier for every notification so a rotated URL is used
+	secrets  dynamic.ResourceInterface
+	notifier *notifier.Notifier
+	log      logr.Logger
+}
+
+// newNotificationSender returns the sender of the resource manager notifications, or nil when they are not configured
+func newNotificationSender(resourceManager v1alpha1.ResourceManagerObject, dynamicClient dynamic.Interface, webhookNotifier *notifier.Notifier, log logr.Logger) (*notificationSender, error) {
+	notifications := resourceManager.GetSpec().Notifications
+	if notifications == nil || webhookNotifier == nil {
+		return nil, nil
+	}
+	tmpl, err := v1alpha1.ParseNotificationTemplate(notifications.PayloadTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	namespace := notifications.SecretRef.Namespace
+	if resourceManager.IsNamespaced() {
+		namespace = resourceManager.GetNamespace()
+	}
+	return &notificationSender{
+		// the sender is used by the workers, it does not share the spec the reconciler decodes into
+		notifications: notifications.DeepCopy(),
+		template:      tmpl,
+		secrets:       dynamicClient.Resource(corev1.SchemeGroupVersion.WithResource("secrets")).Namespace(namespace),
+		notifier:      webhookNotifier,
+		log:           log,
+	}, nil
+}
+
+// send queues the notification for delivery, it is rendered by the notifier so the actions are not delayed by reading the Secret
+func (s *notificationSender) send(data notificationData) {
+	retries := notifier.DefaultRetries
+	if s.notifications.Retries != nil {
+		retries = int(*s.notifications.Retries)
+	}
+	s.notifier.Send(notifier.Notification{
+		Retries: retries,
+		Prepare: func(ctx context.Context, notification *notifier.Notification) error {
+			return s.render(ctx, data, notification)
+		},
+	})
+}
+
+// render sets the webhook URL and the payload of the notification, failures are logged
+func (s *notificationSender) render(ctx context.Context, data notificationData, notification *notifier.Notification) error {
+	url, err := s.webhookURL(ctx)
+	if err != nil {
+		s.log.Error(err, trace(fmt.Sprintf("notification <%s> of <%s> not sent", data.Reason, data.Object)))
+		return err
+	}
+
+	var payload bytes.Buffer
+	if err := s.template.Execute(&payload, data); err != nil {
+		s.log.Error(err, trace(fmt.Sprintf("notification <%s> of <%s> rendering failed", data.Reason, data.Object)))
+		return err
+	}
+	if !json.Valid(payload.Bytes()) {
+		err := fmt.Errorf("invalid JSON payload <%s>", payload.String())
+		s.log.Error(err, trace(fmt.Sprintf("notification <%s> of <%s> rendering failed", data.Reason, data.Object)))
+		return err
+	}
+
+	notification.URL = url
+	notification.Payload = payload.Bytes()
+	return nil
+}
+
+// webhookURL reads the webhook URL from the Secret
+func (s *notificationSender) webhookURL(ctx context.Context) (string, error) {
+	obj, err := s.secrets.Get(ctx, s.notifications.SecretRef.Name, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	secret := &corev1.Secret{}
+	if err := k8sruntime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, secret); err != nil {
+		return "", err
+	}
+
+	key := s.notifications.SecretRef.Key
+	if key == "" {
+		key = "url"
+	}
+	url, ok := secret.Data[key]
+	if !ok || len(url) == 0 {
+		return "", fmt.Errorf("the Secret <%s/%s> has no <%s> key", secret.Namespace, secret.Name, key)
+	}
+	return string(bytes.TrimSpace(url)), nil
+}
@@ -0,0 +1,144 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// DefaultWorkers is the number of workers delivering the notifications when it is not configured
+const DefaultWorkers = 2
+
+// DefaultRetries is the number of retries of a failed delivery when it is not configured
+const DefaultRetries = 3
+
+// queueSize bounds the notifications waiting for a worker, newer notifications are dropped when it is full
+const queueSize = 1000
+
+// Notification is a JSON payload posted to a webhook URL (ex: a Slack or Teams incoming webhook)
+type Notification struct {
+	URL     string
+	Payload []byte
+	// Retries is the number of retries after a failed delivery
+	Retries int
+	// Prepare completes the notification before its delivery (ex: reads the URL from a Secret and renders the payload), it is optional.
+	// It runs on the workers of the notifier, so the senders only queue the notification.
+	Prepare func(ctx context.Context, notification *Notification) error
+}
+
+// Notifier delivers notifications in the background, so the actions are not delayed by slow or failing webhooks.
+// A failed delivery is retried with an exponential backoff, client errors (4xx other than 429) are not retried.
+type Notifier struct {
+	// Client posts the notifications
+	Client *http.Client
+	// Backoff is the delay before the first retry, it doubles on every retry
+	Backoff time.Duration
+
+	workers int
+	queue   chan Notification
+	log     logr.Logger
+}
+
+// New creates a notifier with the given number of workers
+func New(workers int, log logr.Logger) *Notifier {
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	return &Notifier{
+		Client:  &http.Client{Timeout: 10 * time.Second},
+		Backoff: time.Second,
+		workers: workers,
+		queue:   make(chan Notification, queueSize),
+		log:     log,
+	}
+}
+
+// Send queues a notification for delivery, it does not block
+func (n *Notifier) Send(notification Notification) {
+	select {
+	case n.queue <- notification:
+	default:
+		n.log.Info("notification dropped, the queue is full")
+	}
+}
+
+// Start runs the workers until the context is done, it implements manager.Runnable
+func (n *Notifier) Start(ctx context.Context) error {
+	done := make(chan struct{})
+	for i := 0; i < n.workers; i++ {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case notification := <-n.queue:
+					if err := n.deliver(ctx, notification); err != nil {
+						n.log.Error(err, "notification delivery failed")
+					}
+				}
+			}
+		}()
+	}
+	for i := 0; i < n.workers; i++ {
+		<-done
+	}
+	return nil
+}
+
+// deliver posts the notification until it succeeds, it is not retryable or the retries are exhausted
+func (n *Notifier) deliver(ctx context.Context, notification Notification) error {
+	if notification.Prepare != nil {
+		if err := notification.Prepare(ctx, &notification); err != nil {
+			return fmt.Errorf("preparing: %w", err)
+		}
+	}
+
+	backoff := n.Backoff
+	for attempt := 0; ; attempt++ {
+		retryable, err := n.post(ctx, notification)
+		if err == nil {
+			return nil
+		}
+		if !retryable || attempt >= notification.Retries {
+			return fmt.Errorf("after %d attempts: %w", attempt+1, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// post sends the notification once, it returns whether a failure may succeed when retried
+func (n *Notifier) post(ctx context.Context, notification Notification) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, notification.URL, bytes.NewReader(notification.Payload))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("webhook responded %s", resp.Status)
+	default:
+		return false, fmt.Errorf("webhook responded %s", resp.Status)
+	}
+}
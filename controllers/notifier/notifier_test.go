@@ -0,0 +1,107 @@
+package notifier_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"sigs.k8s.io/controller-runtime/pkg/envtest/printer"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/tikalk/resource-manager/controllers/notifier"
+)
+
+var _ = Context("Testing notifier", func() {
+	var n *notifier.Notifier
+	var server *httptest.Server
+	var cancel context.CancelFunc
+
+	// requests counts the requests received by the server, status returns the response of each request
+	var requests int32
+	var status func(request int32) int
+	var payloads chan string
+
+	BeforeEach(func() {
+		requests = 0
+		status = func(int32) int { return http.StatusOK }
+		payloads = make(chan string, 10)
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+			Expect(r.Method).To(Equal(http.MethodPost))
+			Expect(r.Header.Get("Content-Type")).To(Equal("application/json"))
+			body, err := io.ReadAll(r.Body)
+			Expect(err).NotTo(HaveOccurred())
+			payloads <- string(body)
+			w.WriteHeader(status(atomic.AddInt32(&requests, 1)))
+		}))
+
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		n = notifier.New(1, log.Log)
+		n.Backoff = 10 * time.Millisecond
+		go func() {
+			defer GinkgoRecover()
+			Expect(n.Start(ctx)).To(Succeed())
+		}()
+	})
+
+	AfterEach(func() {
+		cancel()
+		server.Close()
+	})
+
+	It("posts the payload to the webhook", func() {
+		n.Send(notifier.Notification{URL: server.URL, Payload: []byte(`{"text":"expiring"}`)})
+		Eventually(payloads, time.Second).Should(Receive(Equal(`{"text":"expiring"}`)))
+		Consistently(func() int32 { return atomic.LoadInt32(&requests) }, 200*time.Millisecond).Should(Equal(int32(1)))
+	})
+
+	It("prepares the notification before its delivery", func() {
+		n.Send(notifier.Notification{Prepare: func(ctx context.Context, notification *notifier.Notification) error {
+			notification.URL = server.URL
+			notification.Payload = []byte(`{"text":"prepared"}`)
+			return nil
+		}})
+		Eventually(payloads, time.Second).Should(Receive(Equal(`{"text":"prepared"}`)))
+	})
+
+	It("retries a failed delivery until it succeeds", func() {
+		status = func(request int32) int {
+			if request < 3 {
+				return http.StatusServiceUnavailable
+			}
+			return http.StatusOK
+		}
+		n.Send(notifier.Notification{URL: server.URL, Payload: []byte(`{}`), Retries: 5})
+		Eventually(func() int32 { return atomic.LoadInt32(&requests) }, time.Second).Should(Equal(int32(3)))
+		Consistently(func() int32 { return atomic.LoadInt32(&requests) }, 200*time.Millisecond).Should(Equal(int32(3)))
+	})
+
+	It("gives up when the retries are exhausted", func() {
+		status = func(int32) int { return http.StatusInternalServerError }
+		n.Send(notifier.Notification{URL: server.URL, Payload: []byte(`{}`), Retries: 2})
+		Eventually(func() int32 { return atomic.LoadInt32(&requests) }, time.Second).Should(Equal(int32(3)))
+		Consistently(func() int32 { return atomic.LoadInt32(&requests) }, 200*time.Millisecond).Should(Equal(int32(3)))
+	})
+
+	It("does not retry a rejected payload", func() {
+		status = func(int32) int { return http.StatusBadRequest }
+		n.Send(notifier.Notification{URL: server.URL, Payload: []byte(`{}`), Retries: 2})
+		Eventually(func() int32 { return atomic.LoadInt32(&requests) }, time.Second).Should(Equal(int32(1)))
+		Consistently(func() int32 { return atomic.LoadInt32(&requests) }, 200*time.Millisecond).Should(Equal(int32(1)))
+	})
+})
+
+func TestNotifier(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecsWithDefaultAndCustomReporters(t,
+		"Notifier Suite",
+		[]Reporter{printer.NewlineReporter{}})
+}
@@ -0,0 +1,137 @@
+package controllers
+
+import (
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+)
+
+// Reasons of the events recorded on the managed objects and on their resource manager
+const (
+	reasonExpiring        = "Expiring"
+	reasonActionSucceeded = "ActionSucceeded"
+	reasonActionFailed    = "ActionFailed"
+	reasonActionDryRun    = "ActionDryRun"
+	reasonRestored        = "Restored"
+	reasonRestoreFailed   = "RestoreFailed"
+)
+
+// recordEvent records an event on the object and on its resource manager, so both are shown by 'kubectl describe',
+// and sends it to the notifications webhook of the resource manager
+func (h *ObjectHandler) recordEvent(eventType string, reason string, message string) {
+	resourceManager := h.getResourceManager()
+	if h.recorder != nil {
+		if obj, ok := h.getObject().(k8sruntime.Object); ok {
+			h.recorder.Event(obj, eventType, reason, message)
+		}
+		h.recorder.Event(resourceManager, eventType, reason, fmt.Sprintf("%s <%s>: %s", resourceManager.GetSpec().ResourceKind, h.fullname, message))
+	}
+	if sender := h.getSender(); sender != nil {
+		sender.send(notificationData{
+			Reason:          reason,
+			Message:         message,
+			Kind:            resourceManager.GetSpec().ResourceKind,
+			Object:          h.fullname.String(),
+			Name:            h.fullname.Name,
+			Namespace:       h.fullname.Namespace,
+			ResourceManager: resourceManager.GetName(),
+			Action:          resourceManager.GetSpec().Action,
+			Time:            time.Now(),
+		})
+	}
+}
+
+// warnLeadTimes returns the lead times of the expiration warnings, invalid durations are rejected by the validation
+func (h *ObjectHandler) warnLeadTimes() []time.Duration {
+	var leads []time.Duration
+	for _, warnBefore := range h.getResourceManager().GetSpec().WarnBefore {
+		if lead, err := time.ParseDuration(warnBefore); err == nil && lead > 0 {
+			leads = append(leads, lead)
+		}
+	}
+	return leads
+}
+
+// warnTaskName returns the scheduler task name of the warning with the given lead time
+func warnTaskName(lead time.Duration) string {
+	return "warn-" + lead.String()
+}
+
+// scheduleWarnings schedules the expiration warnings of the object at their lead times before expiresAt.
+// When the object is scheduled within some of the lead times, the nearest of them is warned about at once,
+// unless it was already warned about the same expiration.
+func (h *ObjectHandler) scheduleWarnings(expiresAt time.Time) {
+	now := time.Now()
+	if !expiresAt.After(now) {
+		return
+	}
+
+	var missed time.Duration
+	for _, lead := range h.warnLeadTimes() {
+		lead := lead
+		warnAt := expiresAt.Add(-lead)
+		if !warnAt.After(now) {
+			if missed == 0 || lead < missed {
+				missed = lead
+			}
+			continue
+		}
+		h.schedule(warnTaskName(lead), warnAt, func() {
+			h.warn(expiresAt, lead)
+		})
+	}
+	if missed != 0 && !h.warned(expiresAt, missed) {
+		h.warn(expiresAt, missed)
+	}
+}
+
+// warned returns true when the warning of the lead time, or of a longer one, was recorded for the expiration
+func (h *ObjectHandler) warned(expiresAt time.Time, lead time.Duration) bool {
+	record := h.lastScheduleRecord()
+	// the persisted times are truncated to seconds
+	if record == nil || record.WarnedFor == nil || record.WarnedFor.Unix() != expiresAt.Unix() {
+		return false
+	}
+	warnedLead, err := time.ParseDuration(record.WarnedLead)
+	return err == nil && lead >= warnedLead
+}
+
+// rememberWarning records the warning in the schedule of the object, so it is not recorded again for the same expiration
+func (h *ObjectHandler) rememberWarning(expiresAt time.Time, lead time.Duration) {
+	record := h.lastScheduleRecord()
+	if record == nil {
+		return
+	}
+	warnedFor := metav1.NewTime(expiresAt)
+	record.WarnedFor = &warnedFor
+	record.WarnedLead = lead.String()
+	h.saveScheduleRecord(record)
+}
+
+// cancelExpiration cancels the scheduled action of the object, its warnings and the restore (or window exit) following it.
+// The restore of a performed action is scheduled again by the rescheduling, from the updated schedule.
+func (h *ObjectHandler) cancelExpiration() {
+	h.scheduler.Cancel(h.taskKey("expire"))
+	h.scheduler.Cancel(h.taskKey("restore"))
+	for _, lead := range h.warnLeadTimes() {
+		h.scheduler.Cancel(h.taskKey(warnTaskName(lead)))
+	}
+}
+
+// warn records an event announcing the action due at expiresAt, lead is the lead time of the warning
+func (h *ObjectHandler) warn(expiresAt time.Time, lead time.Duration) {
+	if h.stopped() {
+		return
+	}
+	message := fmt.Sprintf("the %s action is due at %s (in %s)", h.getResourceManager().GetSpec().Action,
+		expiresAt.Format(time.RFC3339), time.Until(expiresAt).Round(time.Second))
+	if h.getResourceManager().GetSpec().DryRun {
+		message += ", dry-run"
+	}
+	h.log.Info(trace(fmt.Sprintf("object <%s> warned: %s", h.fullname, message)))
+	h.recordEvent(corev1.EventTypeWarning, reasonExpiring, message)
+	h.rememberWarning(expiresAt, lead)
+}
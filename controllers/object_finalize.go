@@ -0,0 +1,197 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/tikalk/resource-manager/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// revertAnnotationPrefix prefixes the annotations holding the merge patch that reverts the patch action of each resource manager.
+// The annotation name is the UID of the resource manager, as the name of the schedule annotation.
+const revertAnnotationPrefix = "revert.resource-management.tikalk.com/"
+
+// actionRevert is the action label of the revert applied when the resource manager is deleted
+const actionRevert = "revert"
+
+// Reasons of the events recorded when the deletion policy of the resource manager is applied
+const (
+	reasonReverted     = "Reverted"
+	reasonRevertFailed = "RevertFailed"
+)
+
+// revertAnnotation returns the name of the annotation holding the revert patch of the resource manager
+func revertAnnotation(resourceManager v1alpha1.ResourceManagerObject) string {
+	return revertAnnotationPrefix + string(resourceManager.GetUID())
+}
+
+// revertableFields returns the fields a revert patch restores: the labels, the foreign annotations and the content but the status
+func revertableFields(obj *unstructured.Unstructured) map[string]interface{} {
+	fields := make(map[string]interface{}, len(obj.Object))
+	for name, value := range obj.Object {
+		switch name {
+		case "apiVersion", "kind", "metadata", "status":
+			continue
+		}
+		fields[name] = value
+	}
+	fields["metadata"] = map[string]interface{}{
+		"labels":      obj.GetLabels(),
+		"annotations": foreignAnnotations(obj.GetAnnotations()),
+	}
+	return fields
+}
+
+// recordRevert persists the merge patch that reverts the patched object to the original one.
+// The patch of the first action is kept, so an object patched twice is reverted to its state before the first patch.
+func (h *ObjectHandler) recordRevert(original *unstructured.Unstructured, patched *unstructured.Unstructured) error {
+	name := revertAnnotation(h.getResourceManager())
+	if _, ok := original.GetAnnotations()[name]; ok {
+		return nil
+	}
+
+	originalJSON, err := json.Marshal(revertableFields(original))
+	if err != nil {
+		return err
+	}
+	patchedJSON, err := json.Marshal(revertableFields(patched))
+	if err != nil {
+		return err
+	}
+	revert, err := jsonpatch.CreateMergePatch(patchedJSON, originalJSON)
+	if err != nil {
+		return fmt.Errorf("objectPatch: cannot create the revert patch of <%s>: %w", h.fullname, err)
+	}
+	if string(revert) == "{}" {
+		return nil
+	}
+	value := string(revert)
+	return h.patchAnnotation(name, &value)
+}
+
+// finalize stops handling the object and applies the deletion policy of its deleted resource manager,
+// then it removes the annotations the resource manager recorded on the object
+func (h *ObjectHandler) finalize(policy string) {
+	h.Stop()
+
+	var err error
+	switch policy {
+	case v1alpha1.OnDeleteExecute:
+		err = h.executePending()
+	case v1alpha1.OnDeleteRevert:
+		err = h.revert()
+	}
+	if apierrors.IsNotFound(err) {
+		return
+	}
+	if err != nil {
+		h.log.Error(err, trace(fmt.Sprintf("object <%s> deletion policy <%s> failed", h.fullname, policy)))
+	}
+
+	if h.getResourceManager().GetSpec().DryRun {
+		return
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]*string{
+				scheduleAnnotation(h.getResourceManager()): nil,
+				revertAnnotation(h.getResourceManager()):   nil,
+			},
+		},
+	})
+	if err == nil {
+		_, err = h.resourceClient.Namespace(h.fullname.Namespace).Patch(context.Background(), h.fullname.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	}
+	if err != nil && !apierrors.IsNotFound(err) {
+		h.log.Error(err, trace(fmt.Sprintf("object <%s> annotations removal failed", h.fullname)))
+	}
+}
+
+// executePending performs the pending action of the object at once: its action when it was not performed,
+// or the restore of a scaled object. Ignored objects and actions that gave up are not performed.
+func (h *ObjectHandler) executePending() error {
+	override, err := parseOverride(h.getObject())
+	if err != nil || (override != nil && override.ignore) {
+		return nil
+	}
+
+	action := h.getResourceManager().GetSpec().Action
+	record := h.loadScheduleRecord(override)
+	switch {
+	case record == nil:
+	case record.ExecutedAt == nil:
+		if record.Attempts > 0 && h.attemptsExhausted(record.Attempts) {
+			return nil
+		}
+	case record.RestoreAt != nil && record.RestoredAt == nil:
+		action = actionRestore
+	default:
+		return nil
+	}
+
+	if h.getResourceManager().GetSpec().DryRun {
+		h.log.Info(trace(fmt.Sprintf("dry-run performing object <%s> action <%s> on deletion", h.fullname, action)))
+		h.countAction(action, v1alpha1.ActionResultDryRun)
+		h.recordEvent(corev1.EventTypeNormal, reasonActionDryRun, fmt.Sprintf("the %s action was not performed on deletion of the resource manager, dry-run", action))
+		return nil
+	}
+
+	h.log.Info(trace(fmt.Sprintf("performing object <%s> action <%s> on deletion...", h.fullname, action)))
+	start := time.Now()
+	if action == actionRestore {
+		err = h.performObjectExit(record)
+	} else {
+		err = h.performObjectAction()
+	}
+	h.observeAction(action, start, err)
+	if err != nil {
+		h.recordEvent(corev1.EventTypeWarning, reasonActionFailed, fmt.Sprintf("the %s action failed on deletion of the resource manager: %s", action, err))
+		return err
+	}
+	h.recordEvent(corev1.EventTypeNormal, reasonActionSucceeded, fmt.Sprintf("the %s action was performed on deletion of the resource manager", action))
+	return nil
+}
+
+// revert reverts the action performed on the object: a scaled object is scaled back to its previous replicas,
+// and a patched object is patched back by its revert patch
+func (h *ObjectHandler) revert() error {
+	if h.getResourceManager().GetSpec().DryRun {
+		return nil
+	}
+
+	start := time.Now()
+	var err error
+	switch h.getResourceManager().GetSpec().Action {
+	case v1alpha1.ActionScale:
+		if _, ok, err := h.getAnnotation(previousReplicasAnnotation); err != nil || !ok {
+			return err
+		}
+		err = h.performObjectRestore()
+	case v1alpha1.ActionPatch:
+		var value string
+		var ok bool
+		value, ok, err = h.getAnnotation(revertAnnotation(h.getResourceManager()))
+		if err != nil || !ok {
+			return err
+		}
+		_, err = h.resourceClient.Namespace(h.fullname.Namespace).Patch(context.Background(), h.fullname.Name, types.MergePatchType, []byte(value), metav1.PatchOptions{})
+	default:
+		return nil
+	}
+	h.observeAction(actionRevert, start, err)
+	if err != nil {
+		h.recordEvent(corev1.EventTypeWarning, reasonRevertFailed, fmt.Sprintf("the %s action revert failed: %s", h.getResourceManager().GetSpec().Action, err))
+		return err
+	}
+	h.log.Info(trace(fmt.Sprintf("object <%s> action <%s> reverted", h.fullname, h.getResourceManager().GetSpec().Action)))
+	h.recordEvent(corev1.EventTypeNormal, reasonReverted, fmt.Sprintf("the %s action was reverted on deletion of the resource manager", h.getResourceManager().GetSpec().Action))
+	return nil
+}
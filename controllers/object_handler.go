@@ -2,16 +2,22 @@ package controllers
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/tikalk/resource-manager/api/v1alpha1"
-	appsv1 "k8s.io/api/apps/v1"
-	v1 "k8s.io/api/core/v1"
+	"github.com/tikalk/resource-manager/controllers/scheduler"
+	"github.com/tikalk/resource-manager/controllers/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/kubernetes"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/record"
 
 	"k8s.io/apimachinery/pkg/types"
 )
@@ -19,24 +25,56 @@ import (
 // ObjectHandler manage a single object like deployment, namespace, etc...
 // according to the action definition provided by user like "delete" / "patch" an object
 type ObjectHandler struct {
-	resourceManager *v1alpha1.ResourceManager
-	object          interface{}
-	fullname        types.NamespacedName
-	creationTime    time.Time
-	stopper         chan struct{}
-	clientset       *kubernetes.Clientset
-	log             logr.Logger
+	fullname       types.NamespacedName
+	creationTime   time.Time
+	stopper        chan struct{}
+	resourceClient dynamic.NamespaceableResourceInterface
+	// scheduler runs the tasks of the object (ex: the action) when they are due
+	scheduler *scheduler.Scheduler
+	// recorder records the warnings and the action results as events, it may be nil
+	recorder record.EventRecorder
+	log      logr.Logger
+
+	// mu guards the resource manager, the object and the tracked state below, which is read when the status is written.
+	// The resource manager is replaced when its spec is updated, without restarting the handler.
+	mu sync.Mutex
+	// state is the lifecycle state of the handler, the tasks of a stopped handler are not scheduled
+	state           handlerState
+	resourceManager v1alpha1.ResourceManagerObject
+	// sender posts the warnings and the action results to the notifications webhook, it is nil when they are not configured
+	sender *notificationSender
+	// owner is the resource manager handler the object handler reports its state changes and its completion to
+	owner objectOwner
+	// object is the last observed state of the object
+	object interface{}
+	// record is the schedule last loaded or saved by the handler, it is newer than the schedule annotation of the observed object
+	record           *scheduleRecord
+	expiresAt        time.Time
+	override         string
+	extensions       int32
+	attempts         int32
+	lastActionTime   time.Time
+	lastActionResult string
+	message          string
+}
+
+// objectOwner is notified by the object handlers it registered
+type objectOwner interface {
+	// markChanged reports that the tracked state of an object changed
+	markChanged()
+	// objectDone reports that the object handler completed, ex: the object was deleted by the action
+	objectDone(objHandler *ObjectHandler)
 }
 
 // NewObjectHandler create a new ObjectHandler to manage a single kubernetes object
-func NewObjectHandler(resourceManager *v1alpha1.ResourceManager, obj interface{}, clientset *kubernetes.Clientset, log logr.Logger) (*ObjectHandler, error) {
+func NewObjectHandler(resourceManager v1alpha1.ResourceManagerObject, obj interface{}, resourceClient dynamic.NamespaceableResourceInterface, taskScheduler *scheduler.Scheduler, recorder record.EventRecorder, sender *notificationSender, owner objectOwner, log logr.Logger) (*ObjectHandler, error) {
 	// extract the NamespacedName of the object for storage
-	fullName, err := extractFullname(resourceManager.Spec.ResourceKind, obj)
+	fullName, err := extractFullname(obj)
 	if err != nil {
 		return nil, err
 	}
 
-	creationTime, err := extractCreationTime(resourceManager.Spec.ResourceKind, obj)
+	creationTime, err := extractCreationTime(obj)
 	if err != nil {
 		return nil, err
 	}
@@ -48,49 +86,226 @@ func NewObjectHandler(resourceManager *v1alpha1.ResourceManager, obj interface{}
 		creationTime:    creationTime,
 		stopper:         make(chan struct{}),
 		resourceManager: resourceManager,
-		clientset:       clientset,
+		resourceClient:  resourceClient,
+		scheduler:       taskScheduler,
+		recorder:        recorder,
+		sender:          sender,
+		owner:           owner,
 		log:             log,
 	}
 	return objectHandler, nil
 }
 
-// extractFullname extract the full name of the object according to object kind
-func extractFullname(kind string, obj interface{}) (fullname types.NamespacedName, err error) {
-	switch kind {
-	case "Namespace":
-		fullname = types.NamespacedName{Name: obj.(*v1.Namespace).Name, Namespace: obj.(*v1.Namespace).Namespace}
-	case "Deployment":
-		fullname = types.NamespacedName{Name: obj.(*appsv1.Deployment).Name, Namespace: obj.(*appsv1.Deployment).Namespace}
-	default:
-		err = fmt.Errorf("extractFullname error: unxpected object kind <%s>", kind)
+// taskKey returns the scheduler key of an object task, all the tasks of the object share the same owner
+func (h *ObjectHandler) taskKey(name string) scheduler.Key {
+	return scheduler.Key{
+		Owner: fmt.Sprintf("%s/%s", h.getResourceManager().GetUID(), h.fullname),
+		Name:  name,
 	}
-	return fullname, err
 }
 
-// extractCreationTime extract the creation time of the object according to object kind
-func extractCreationTime(kind string, obj interface{}) (time time.Time, err error) {
-	switch kind {
-	case "Namespace":
-		time = obj.(*v1.Namespace).ObjectMeta.CreationTimestamp.Time
-	case "Deployment":
-		time = obj.(*appsv1.Deployment).ObjectMeta.CreationTimestamp.Time
-	default:
-		err = fmt.Errorf("extractCreationTime: unxpected object kind <%s>", kind)
+// UID returns the UID of the handled object
+func (h *ObjectHandler) UID() types.UID {
+	return extractUID(h.getObject())
+}
+
+// getResourceManager returns the resource manager of the object
+func (h *ObjectHandler) getResourceManager() v1alpha1.ResourceManagerObject {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.resourceManager
+}
+
+// getSender returns the sender of the notifications, nil when they are not configured
+func (h *ObjectHandler) getSender() *notificationSender {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.sender
+}
+
+// getOwner returns the resource manager handler the object handler reports to
+func (h *ObjectHandler) getOwner() objectOwner {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.owner
+}
+
+// notify reports that the tracked state of the object changed
+func (h *ObjectHandler) notify() {
+	h.getOwner().markChanged()
+}
+
+// done reports that the object handler completed, its owner deregisters and stops it
+func (h *ObjectHandler) done() {
+	h.log.Info(trace(fmt.Sprintf("object <%s> handler completed", h.fullname)))
+	h.getOwner().objectDone(h)
+}
+
+// reconfigure applies an updated spec of the resource manager, or hands the object over to a new handler of the resource manager.
+// The action is rescheduled when reschedule is true, otherwise the scheduled tasks use the updated spec when they run.
+func (h *ObjectHandler) reconfigure(resourceManager v1alpha1.ResourceManagerObject, sender *notificationSender, owner objectOwner, reschedule bool) {
+	if reschedule {
+		// the warnings are canceled by the lead times of the previous spec
+		h.cancelExpiration()
+	}
+	h.mu.Lock()
+	h.resourceManager = resourceManager
+	h.sender = sender
+	h.owner = owner
+	h.mu.Unlock()
+
+	if reschedule {
+		h.log.Info(trace(fmt.Sprintf("object <%s> schedule changed. Rescheduling...", h.fullname)))
+		h.Start()
+	}
+	h.notify()
+}
+
+// getObject returns the last observed state of the object
+func (h *ObjectHandler) getObject() interface{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.object
+}
+
+// Update records the last observed state of the object, it is used when the action is performed.
+// The action is rescheduled when the override or lease annotations of the object changed.
+func (h *ObjectHandler) Update(obj interface{}) {
+	h.mu.Lock()
+	oldObj := h.object
+	h.object = obj
+	h.mu.Unlock()
+
+	if overrideChanged(oldObj, obj) {
+		h.log.Info(trace(fmt.Sprintf("object <%s> override changed. Rescheduling...", h.fullname)))
+		h.cancelExpiration()
+		h.Start()
+	}
+}
+
+// TrackedObject returns the object state as reported in the ResourceManager status
+func (h *ObjectHandler) TrackedObject() v1alpha1.TrackedObject {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	tracked := v1alpha1.TrackedObject{
+		Name:             h.fullname.Name,
+		Namespace:        h.fullname.Namespace,
+		LastActionResult: h.lastActionResult,
+		Message:          h.message,
+		Override:         h.override,
+		Extensions:       h.extensions,
+		Attempts:         h.attempts,
+	}
+	if !h.expiresAt.IsZero() {
+		tracked.ExpiresAt = statusTime(h.expiresAt)
+	}
+	if !h.lastActionTime.IsZero() {
+		tracked.LastActionTime = statusTime(h.lastActionTime)
+	}
+	if h.record != nil && h.record.LastRunAt != nil {
+		tracked.LastRunTime = statusTime(h.record.LastRunAt.Time)
+	}
+	return tracked
+}
+
+// statusTime returns the time as it is read back from the status, which the API server keeps with a second precision.
+// An unchanged status then compares equal to the status read back, and it is not written again.
+func statusTime(t time.Time) *metav1.Time {
+	return &metav1.Time{Time: t.Truncate(time.Second)}
+}
+
+// pendingActionAt returns the time of the action (or restore) that was not performed yet, zero when none is pending
+func (h *ObjectHandler) pendingActionAt() time.Time {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.expiresAt.IsZero() || h.lastActionTime.After(h.expiresAt) {
+		return time.Time{}
+	}
+	return h.expiresAt
+}
+
+// setExpiresAt records the calculated expiration time of the object
+func (h *ObjectHandler) setExpiresAt(expiresAt time.Time) {
+	h.mu.Lock()
+	h.expiresAt = expiresAt.Truncate(time.Second)
+	h.mu.Unlock()
+	h.notify()
+}
+
+// setOverride records the override annotation of the object, when the object is (re)scheduled
+func (h *ObjectHandler) setOverride(override *objectOverride) {
+	h.mu.Lock()
+	h.override = override.String()
+	// the failure of a previous schedule is outdated
+	h.message = ""
+	h.mu.Unlock()
+	h.notify()
+}
+
+// setFailure records an error that prevents the object action
+func (h *ObjectHandler) setFailure(err error) {
+	h.mu.Lock()
+	h.message = err.Error()
+	h.mu.Unlock()
+	h.notify()
+}
+
+// setActionResult records the result of the last action performed on the object
+func (h *ObjectHandler) setActionResult(result string, err error) {
+	h.mu.Lock()
+	h.lastActionTime = time.Now()
+	h.lastActionResult = result
+	h.message = ""
+	if err != nil {
+		h.message = err.Error()
 	}
-	return time, err
+	h.mu.Unlock()
+	h.notify()
+}
+
+// extractFullname extract the full name of the object, cluster-scoped objects have an empty namespace
+func extractFullname(obj interface{}) (fullname types.NamespacedName, err error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return fullname, fmt.Errorf("extractFullname error: %w", err)
+	}
+	return types.NamespacedName{Name: accessor.GetName(), Namespace: accessor.GetNamespace()}, nil
+}
+
+// extractUID extract the UID of the object
+func extractUID(obj interface{}) types.UID {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return ""
+	}
+	return accessor.GetUID()
+}
+
+// extractCreationTime extract the creation time of the object
+func extractCreationTime(obj interface{}) (time time.Time, err error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return time, fmt.Errorf("extractCreationTime error: %w", err)
+	}
+	return accessor.GetCreationTimestamp().Time, nil
 }
 
 // performObjectAction executes the desired action on an object
 func (h *ObjectHandler) performObjectAction() (err error) {
-	switch h.resourceManager.Spec.Action {
-	case "delete":
+	switch h.getResourceManager().GetSpec().Action {
+	case v1alpha1.ActionDelete:
 		err = h.performObjectDelete()
 		break
-	case "patch":
+	case v1alpha1.ActionPatch:
 		err = h.performObjectPatch()
 		break
+	case v1alpha1.ActionScale:
+		err = h.performObjectScale()
+		break
 	default:
-		err = errors.New(fmt.Sprintf("objectAction: unexpected action %s", h.resourceManager.Spec.Action))
+		err = errors.New(fmt.Sprintf("objectAction: unexpected action %s", h.getResourceManager().GetSpec().Action))
 	}
 	return err
 }
@@ -98,109 +313,516 @@ func (h *ObjectHandler) performObjectAction() (err error) {
 // performObjectDelete delete a single object
 func (h *ObjectHandler) performObjectDelete() (err error) {
 	var opts metav1.DeleteOptions
-	switch h.resourceManager.Spec.ResourceKind {
-	case "Namespace":
-		err = h.clientset.CoreV1().Namespaces().Delete(context.Background(), h.fullname.Name, opts)
-	case "Deployment":
-		err = h.clientset.AppsV1().Deployments(h.fullname.Namespace).Delete(context.Background(), h.fullname.Name, opts)
-	default:
-		err = fmt.Errorf("objectDelete: unxpected object kind <%s>", h.resourceManager.Spec.ResourceKind)
-	}
-	return err
+	return h.resourceClient.Namespace(h.fullname.Namespace).Delete(context.Background(), h.fullname.Name, opts)
 }
 
-type patchUInt32Value struct {
-	Op    string `json:"op"`
-	Path  string `json:"path"`
-	Value uint32 `json:"value"`
+// fieldManager is the name the operator is recorded with in the managed fields of the objects it patches
+const fieldManager = "resource-manager"
+
+// patchTypes maps the spec patch types to the API patch types
+var patchTypes = map[string]types.PatchType{
+	"":                          types.StrategicMergePatchType,
+	v1alpha1.PatchTypeStrategic: types.StrategicMergePatchType,
+	v1alpha1.PatchTypeMerge:     types.MergePatchType,
+	v1alpha1.PatchTypeJSON:      types.JSONPatchType,
+	v1alpha1.PatchTypeApply:     types.ApplyPatchType,
 }
 
 // performObjectPatch patch a single object
 func (h *ObjectHandler) performObjectPatch() (err error) {
-	//var pt types.PatchType
+	// the original object is kept to revert the patch when the resource manager is deleted
+	var original *unstructured.Unstructured
+	if h.getResourceManager().GetSpec().OnDelete == v1alpha1.OnDeleteRevert {
+		if original, err = h.resourceClient.Namespace(h.fullname.Namespace).Get(context.Background(), h.fullname.Name, metav1.GetOptions{}); err != nil {
+			return err
+		}
+	}
+
+	patched, err := h.patchObject(h.getResourceManager().GetSpec().ActionParam)
+	if err != nil || original == nil {
+		return err
+	}
+	if err := h.recordRevert(original, patched); err != nil {
+		h.log.Error(err, trace(fmt.Sprintf("object <%s> revert patch recording failed", h.fullname)))
+	}
+	return nil
+}
+
+// patchObject renders a patch template (ex: the action param) and patches the object with the patch type of the resource manager
+func (h *ObjectHandler) patchObject(actionParam string) (*unstructured.Unstructured, error) {
+	patchType, ok := patchTypes[h.getResourceManager().GetSpec().PatchType]
+	if !ok {
+		return nil, fmt.Errorf("objectPatch: unexpected patch type %s", h.getResourceManager().GetSpec().PatchType)
+	}
+
+	data, err := h.renderActionParam(actionParam, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	opts := metav1.PatchOptions{FieldManager: fieldManager}
+	if patchType == types.ApplyPatchType {
+		if data, err = h.applyConfiguration(data); err != nil {
+			return nil, err
+		}
+		// the operator owns the fields of the action, even when they were set by others
+		force := true
+		opts.Force = &force
+	}
+	return h.resourceClient.Namespace(h.fullname.Namespace).Patch(context.Background(), h.fullname.Name, patchType, data, opts)
+}
+
+// applyConfiguration completes a server-side apply patch with the identity of the object
+func (h *ObjectHandler) applyConfiguration(patch []byte) ([]byte, error) {
+	configuration := &unstructured.Unstructured{}
+	if err := json.Unmarshal(patch, &configuration.Object); err != nil {
+		return nil, fmt.Errorf("objectPatch: invalid apply patch: %w", err)
+	}
+
+	obj, err := meta.Accessor(h.getObject())
+	if err != nil {
+		return nil, err
+	}
+	typed, err := meta.TypeAccessor(h.getObject())
+	if err != nil {
+		return nil, err
+	}
+	configuration.SetAPIVersion(typed.GetAPIVersion())
+	configuration.SetKind(typed.GetKind())
+	configuration.SetName(obj.GetName())
+	configuration.SetNamespace(obj.GetNamespace())
+	return json.Marshal(configuration.Object)
+}
 
-	//data := fmt.Sprintf(`{"metadata":{"annotations":{"kubectl.kubernetes.io/restartedAt":"%s"}}}`, time.Now().String())
-	var data string
-	data = h.resourceManager.Spec.ActionParam
+// calculateExpiration calculates the expiration time of the object according to the resource manager condition
+func (h *ObjectHandler) calculateExpiration(now time.Time) (time.Time, error) {
+	return h.calculateDueTime(h.getResourceManager().GetSpec().Condition, h.creationTime, now)
+}
 
-	switch h.resourceManager.Spec.ResourceKind {
-	case "Namespace":
-		_, err = h.clientset.CoreV1().Namespaces().Patch(context.Background(), h.fullname.Name, types.StrategicMergePatchType, []byte(data), metav1.PatchOptions{FieldManager: "kubectl-rollout"})
-	case "Deployment":
-		_, err = h.clientset.AppsV1().Deployments(h.fullname.Namespace).Patch(context.Background(), h.fullname.Name, types.StrategicMergePatchType, []byte(data), metav1.PatchOptions{FieldManager: "kubectl-rollout"})
+// calculateObjectExpiration calculates the expiration time of the object, the override annotations of the object take precedence.
+// An overridden expiration is kept within the maximum lifetime of the lease policy, as the lease extensions are.
+func (h *ObjectHandler) calculateObjectExpiration(override *objectOverride, now time.Time) (time.Time, error) {
+	var expiresAt time.Time
+	switch {
+	case override == nil:
+		return h.calculateExpiration(now)
+	case override.annotation == expireAtAnnotation:
+		expiresAt = override.expireAt
+	case override.annotation == expireAfterAnnotation:
+		expiresAt = h.creationTime.Add(override.expireAfter)
 	default:
-		err = fmt.Errorf("objectDelete: unxpected object kind <%s>", h.resourceManager.Spec.ResourceKind)
+		return h.calculateExpiration(now)
 	}
-	return err
+
+	latest, err := h.latestExpiration()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !latest.IsZero() && expiresAt.After(latest) {
+		h.log.Info(trace(fmt.Sprintf("object <%s> override <%s> shortened to the maximum lifetime <%s>", h.fullname, override, latest)))
+		expiresAt = latest
+	}
+	return expiresAt, nil
 }
 
-// Run calculates the expiration time of an object and perform the desired action when the time arrives
-func (h *ObjectHandler) Run() {
-	var wait time.Duration
+// calculateDueTime calculates the time an expiration is due, 'after' is counted from since
+func (h *ObjectHandler) calculateDueTime(cond v1alpha1.Expiration, since time.Time, now time.Time) (time.Time, error) {
+	location, err := cond.Location()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("cannot load time zone <%s>: %w", cond.TimeZone, err)
+	}
+	// the wall-clock expirations are evaluated in the configured time zone
+	now = now.In(location)
 
-	cond := h.resourceManager.Spec.Condition
 	if cond.ExpireAfter != "" {
 		expireAfter, err := time.ParseDuration(cond.ExpireAfter)
 		if err != nil {
-			h.log.Error(err, trace(fmt.Sprintf("cannot parse ExpireAfter parameter <%s>. object handler <%s> aborted", cond.ExpireAfter, h.fullname)))
+			return time.Time{}, fmt.Errorf("cannot parse ExpireAfter parameter <%s>: %w", cond.ExpireAfter, err)
+		}
+		h.log.Info(trace(fmt.Sprintf("object age expiration <%s> after <%s> age <%s>", h.fullname, expireAfter.String(), now.Sub(since).String())))
+		return since.Add(expireAfter), nil
+	} else if cond.ExpireAt != "" {
+		expiration, err := utils.NextTimeOfDay(now, cond.ExpireAt)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("cannot parse ExpireAt parameter <%s>: %w", cond.ExpireAt, err)
+		}
+		h.log.Info(trace(fmt.Sprintf("object time expiration <%s> expireAt <%s> now <%s>", h.fullname, cond.ExpireAt, now.String())))
+		return expiration, nil
+	} else if cond.Schedule != "" {
+		expiration, err := utils.NextScheduleTime(now, cond.Schedule)
+		if err != nil {
+			return time.Time{}, err
+		}
+		h.log.Info(trace(fmt.Sprintf("object schedule expiration <%s> schedule <%s> now <%s>", h.fullname, cond.Schedule, now.String())))
+		return expiration, nil
+	} else if cond.Window != nil {
+		open, err := inWindow(cond, now)
+		if err != nil {
+			return time.Time{}, err
+		}
+		// an open window brings the object to the state of the window at once, ex: when the operator starts mid-window
+		if open {
+			h.log.Info(trace(fmt.Sprintf("object window expiration <%s> window <%s - %s> is open now <%s>", h.fullname, cond.Window.Start, cond.Window.End, now.String())))
+			return now, nil
+		}
+		expiration, err := utils.NextScheduleTime(now, cond.Window.Start)
+		if err != nil {
+			return time.Time{}, err
+		}
+		h.log.Info(trace(fmt.Sprintf("object window expiration <%s> window <%s - %s> now <%s>", h.fullname, cond.Window.Start, cond.Window.End, now.String())))
+		return expiration, nil
+	}
+	return time.Time{}, errors.New("expiration is not configured")
+}
+
+// Start schedules the object handler to run by the scheduler workers, a stopped handler is not started
+func (h *ObjectHandler) Start() {
+	h.mu.Lock()
+	if h.state == handlerStopped {
+		h.mu.Unlock()
+		return
+	}
+	h.state = handlerRunning
+	h.mu.Unlock()
+
+	h.schedule("run", time.Now(), h.Run)
+}
+
+// schedule schedules a task of the object unless the handler is stopped. The tasks of the handlers of the same object share
+// their keys, so a task that runs while its handler is stopped must not replace the tasks of the handler replacing it.
+func (h *ObjectHandler) schedule(name string, dueAt time.Time, run func()) {
+	key := h.taskKey(name)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.state == handlerStopped {
+		return
+	}
+	h.scheduler.Schedule(key, dueAt, run)
+}
+
+// Run calculates the expiration time of an object and schedules the desired action for the time it arrives.
+// The expiration time is persisted on the object, so it is honored after the operator restarts.
+func (h *ObjectHandler) Run() {
+	if h.stopped() {
+		return
+	}
+
+	override, err := parseOverride(h.getObject())
+	h.setOverride(override)
+	if err != nil {
+		// the owner meant to change the expiration, so the expiration of the resource manager is not applied
+		h.log.Error(err, trace(fmt.Sprintf("object handler <%s> aborted", h.fullname)))
+		h.setExpiresAt(time.Time{})
+		h.setFailure(err)
+		return
+	}
+	if override != nil && override.ignore {
+		h.log.Info(trace(fmt.Sprintf("object <%s> is ignored", h.fullname)))
+		h.setExpiresAt(time.Time{})
+		return
+	}
+
+	record := h.loadScheduleRecord(override)
+	if record != nil && record.ExecutedAt != nil {
+		h.log.Info(trace(fmt.Sprintf("object <%s> action <%s> already performed at <%s>", h.fullname, h.getResourceManager().GetSpec().Action, record.ExecutedAt)))
+		h.mu.Lock()
+		h.expiresAt = record.DueAt.Time
+		h.lastActionTime = record.ExecutedAt.Time
+		h.lastActionResult = v1alpha1.ActionResultSucceeded
+		h.attempts = record.Attempts
+		h.mu.Unlock()
+		h.notify()
+
+		if record.RestoreAt != nil && record.RestoredAt == nil {
+			if record.RestoreAttempts > 0 && h.attemptsExhausted(record.RestoreAttempts) {
+				h.log.Info(trace(fmt.Sprintf("object <%s> restore gave up after <%d> attempts", h.fullname, record.RestoreAttempts)))
+				h.rearm(record)
+				return
+			}
+			h.scheduleRestore(record)
 			return
 		}
-		age := time.Now().Sub(h.creationTime)
-		wait = expireAfter - age
+		h.rearm(record)
+		return
+	}
 
-		h.log.Info(trace(fmt.Sprintf("object age expiration <%s> after <%s> age <%s> wait <%s>", h.fullname, expireAfter.String(), age.String(), wait.String())))
-	} else if cond.ExpireAt != "" {
-		expireAt, err := time.Parse("15:04", cond.ExpireAt)
+	changed := false
+	if record != nil {
+		h.log.Info(trace(fmt.Sprintf("object <%s> expiration <%s> restored", h.fullname, record.DueAt)))
+	} else {
+		expiresAt, err := h.calculateObjectExpiration(override, time.Now())
 		if err != nil {
-			h.log.Error(err, trace(fmt.Sprintf("Failed to parse ExpireAt parameter <%s>. object handler <%s> aborted.", cond.ExpireAt, h.fullname)))
+			h.log.Error(err, trace(fmt.Sprintf("object handler <%s> aborted", h.fullname)))
+			h.setFailure(err)
 			return
 		}
+		record = &scheduleRecord{
+			DueAt:      metav1.NewTime(expiresAt),
+			Expiration: h.getResourceManager().GetSpec().Condition,
+			Override:   override.String(),
+			LastRunAt:  h.lastRunAt(),
+		}
+		changed = true
+	}
 
-		now := time.Now()
-		if expireAt.Hour()*60+expireAt.Minute() > now.Hour()*60+now.Minute() {
-			// Today
-			wait = time.Date(now.Year(), now.Month(), now.Day(), expireAt.Hour(), expireAt.Minute(), 0, 0, now.Location()).Sub(now)
-		} else {
-			// Tomorrow
-			tomorrow := now.Add(24 * time.Hour)
-			wait = time.Date(tomorrow.Year(), tomorrow.Month(), tomorrow.Day(), expireAt.Hour(), expireAt.Minute(), 0, 0, tomorrow.Location()).Sub(now)
+	// an invalid or rejected lease keeps the expiration, so it cannot be used to escape the limits of the resource manager
+	lease, err := parseLease(h.getObject())
+	if err == nil {
+		var extended bool
+		extended, err = h.extendLease(record, lease, override)
+		changed = changed || extended
+	}
+	if err != nil {
+		h.log.Error(err, trace(fmt.Sprintf("object <%s> lease ignored", h.fullname)))
+		h.setFailure(err)
+	}
+	if changed {
+		h.saveScheduleRecord(record)
+	}
+	h.mu.Lock()
+	h.extensions = record.Extensions
+	h.attempts = record.Attempts
+	h.mu.Unlock()
+
+	expiresAt := record.DueAt.Time
+	if record.Attempts > 0 {
+		// the action failed before, it is retried unless its attempts are exhausted
+		if h.attemptsExhausted(record.Attempts) {
+			h.log.Info(trace(fmt.Sprintf("object <%s> action <%s> gave up after <%d> attempts", h.fullname, h.getResourceManager().GetSpec().Action, record.Attempts)))
+			h.mu.Lock()
+			h.expiresAt = expiresAt
+			h.lastActionResult = v1alpha1.ActionResultFailed
+			h.mu.Unlock()
+			h.notify()
+			h.rearm(record)
+			return
+		}
+		if record.RetryAt != nil && record.RetryAt.After(expiresAt) {
+			expiresAt = record.RetryAt.Time
 		}
+	}
+	h.scheduleAction(record, expiresAt)
+}
+
+// scheduleAction schedules the action of the record at expiresAt, and its warnings
+func (h *ObjectHandler) scheduleAction(record *scheduleRecord, expiresAt time.Time) {
+	h.setExpiresAt(expiresAt)
 
-		h.log.Info(trace(fmt.Sprintf("object time expiration <%s> expireAt <%s> now <%s> wait <%s>", h.fullname, expireAt.String(), now.String(), wait)))
+	if wait := time.Until(expiresAt); wait <= 0 {
+		h.log.Info(trace(fmt.Sprintf("object already expired <%s>", h.fullname)))
 	} else {
-		h.log.Error(errors.New("expiration is not configured"), trace(fmt.Sprintf("object handler <%s> aborted", h.fullname)))
+		h.log.Info(trace(fmt.Sprintf("object <%s> expires at <%s> wait <%s>", h.fullname, expiresAt, wait)))
+	}
+	h.schedule("expire", expiresAt, func() {
+		h.expire(record)
+	})
+	h.scheduleWarnings(expiresAt)
+}
+
+// recurring returns true when the action of the record is performed again at the next occurrence of the condition,
+// as set by the recurrence of the resource manager. The override annotations and the age condition set a single expiration.
+func (h *ObjectHandler) recurring(record *scheduleRecord) bool {
+	if !h.getResourceManager().GetSpec().Recurs() || record.Override != "" {
+		return false
+	}
+	return record.Expiration.Recurs()
+}
+
+// rearm schedules the next occurrence of a recurring action once the previous one is over (performed and restored, or gave up).
+// The next occurrence is persisted before it is scheduled, so it is neither skipped nor repeated after a restart.
+func (h *ObjectHandler) rearm(previous *scheduleRecord) {
+	if !h.recurring(previous) || h.stopped() {
 		return
 	}
 
-	if wait <= 0 {
-		h.log.Info(trace(fmt.Sprintf("object already expired <%s>", h.fullname)))
+	// the next occurrence follows the previous one, even when the previous one was performed early by a retry
+	since := time.Now()
+	if previous.DueAt.After(since) {
+		since = previous.DueAt.Time
+	}
+	var dueAt time.Time
+	var err error
+	if previous.Expiration.Window != nil && previous.RestoredAt == nil {
+		// the window may still be open, ex: the action gave up, it is performed again when the next window opens.
+		// Once the object exits its window, it enters the next one at once if it is already open.
+		dueAt, err = nextWindowStart(previous.Expiration, since)
 	} else {
-		select {
-		case <-h.stopper:
-			h.log.Info(trace(fmt.Sprintf("h aborted for object<%s>", h.fullname)))
+		dueAt, err = h.calculateDueTime(previous.Expiration, h.creationTime, since)
+	}
+	if err != nil {
+		h.log.Error(err, trace(fmt.Sprintf("object <%s> rearming failed", h.fullname)))
+		h.setFailure(err)
+		return
+	}
+	// the lease that extended the previous occurrence does not extend the next one
+	record := &scheduleRecord{
+		DueAt:      metav1.NewTime(dueAt),
+		Expiration: previous.Expiration,
+		Lease:      previous.Lease,
+		LastRunAt:  previous.LastRunAt,
+	}
+	h.saveScheduleRecord(record)
+	h.mu.Lock()
+	h.extensions = 0
+	h.attempts = 0
+	h.mu.Unlock()
+
+	h.log.Info(trace(fmt.Sprintf("object <%s> action <%s> rearmed", h.fullname, h.getResourceManager().GetSpec().Action)))
+	h.scheduleAction(record, dueAt)
+}
+
+// expire performs the desired action on the object and records its result
+func (h *ObjectHandler) expire(record *scheduleRecord) {
+	if h.stopped() {
+		h.log.Info(trace(fmt.Sprintf("h aborted for object<%s>", h.fullname)))
+		return
+	}
+	h.log.Info(trace(fmt.Sprintf("object expired <%s>", h.fullname)))
+
+	// the window may close before its action is performed, ex: while the operator was down or the action was retried
+	if record.Expiration.Window != nil {
+		if open, err := inWindow(record.Expiration, time.Now()); err == nil && !open {
+			h.log.Info(trace(fmt.Sprintf("object <%s> window closed before the action <%s> was performed", h.fullname, h.getResourceManager().GetSpec().Action)))
+			h.rearm(record)
 			return
-		case <-time.After(wait):
-			h.log.Info(trace(fmt.Sprintf("object expired <%s>", h.fullname)))
-			break
 		}
 	}
 
-	if h.resourceManager.Spec.DryRun {
-		h.log.Info(trace(fmt.Sprintf("dry-run performing object <%s> action <%s> ", h.fullname, h.resourceManager.Spec.Action)))
+	if h.getResourceManager().GetSpec().DryRun {
+		h.log.Info(trace(fmt.Sprintf("dry-run performing object <%s> action <%s> ", h.fullname, h.getResourceManager().GetSpec().Action)))
+		h.setActionResult(v1alpha1.ActionResultDryRun, nil)
+		h.countAction(h.getResourceManager().GetSpec().Action, v1alpha1.ActionResultDryRun)
+		h.recordEvent(corev1.EventTypeNormal, reasonActionDryRun, fmt.Sprintf("the %s action was not performed, dry-run", h.getResourceManager().GetSpec().Action))
+		h.rearm(record)
 	} else {
-		h.log.Info(trace(fmt.Sprintf("performing object <%s> action <%s>...", h.fullname, h.resourceManager.Spec.Action)))
+		h.log.Info(trace(fmt.Sprintf("performing object <%s> action <%s>...", h.fullname, h.getResourceManager().GetSpec().Action)))
+		start := time.Now()
 		err := h.performObjectAction()
+		h.observeAction(h.getResourceManager().GetSpec().Action, start, err)
+		record.Attempts++
+		record.RetryAt = nil
+		h.setAttempts(record.Attempts)
 		if err != nil {
-			h.log.Error(err, trace(fmt.Sprintf("object <%s> action <%s> failed", h.fullname, h.resourceManager.Spec.Action)))
+			h.log.Error(err, trace(fmt.Sprintf("object <%s> action <%s> failed", h.fullname, h.getResourceManager().GetSpec().Action)))
+			h.setActionResult(v1alpha1.ActionResultFailed, err)
+			if retryAt, ok := h.nextRetry(record.Attempts, err); ok {
+				h.recordEvent(corev1.EventTypeWarning, reasonActionFailed, fmt.Sprintf("the %s action failed (attempt %d), retrying at %s: %s",
+					h.getResourceManager().GetSpec().Action, record.Attempts, retryAt.Format(time.RFC3339), err))
+				record.RetryAt = &metav1.Time{Time: retryAt}
+				h.saveScheduleRecord(record)
+				h.scheduleRetry(h.getResourceManager().GetSpec().Action, "expire", retryAt, func() {
+					h.expire(record)
+				})
+			} else {
+				h.recordEvent(corev1.EventTypeWarning, reasonActionFailed, fmt.Sprintf("the %s action failed after %d attempts: %s",
+					h.getResourceManager().GetSpec().Action, record.Attempts, err))
+				h.saveScheduleRecord(record)
+				h.rearm(record)
+			}
 		} else {
-			h.log.Info(trace(fmt.Sprintf("object <%s> action <%s> finished", h.fullname, h.resourceManager.Spec.Action)))
+			h.log.Info(trace(fmt.Sprintf("object <%s> action <%s> finished", h.fullname, h.getResourceManager().GetSpec().Action)))
+			h.setActionResult(v1alpha1.ActionResultSucceeded, nil)
+			h.recordEvent(corev1.EventTypeNormal, reasonActionSucceeded, fmt.Sprintf("the %s action was performed", h.getResourceManager().GetSpec().Action))
+
+			// a deleted object has nothing to record and nothing left to handle,
+			// other objects must not be acted on again after a restart
+			if h.getResourceManager().GetSpec().Action == v1alpha1.ActionDelete {
+				h.done()
+			} else {
+				executedAt := metav1.Now()
+				record.ExecutedAt = &executedAt
+				record.LastRunAt = &executedAt
+				if restoreAt, err := h.calculateRestoreTime(record, executedAt.Time); err != nil {
+					h.log.Error(err, trace(fmt.Sprintf("object <%s> restore scheduling failed", h.fullname)))
+					h.setFailure(err)
+				} else if restoreAt != nil {
+					record.RestoreAt = &metav1.Time{Time: *restoreAt}
+				}
+				h.saveScheduleRecord(record)
+
+				if record.RestoreAt != nil {
+					h.scheduleRestore(record)
+				} else {
+					h.rearm(record)
+				}
+			}
 		}
 
 	}
 
 }
 
-// Stop will be called, When the ObjectHandler requires to stop.
+// scheduleRestore schedules undoing the action: scaling the object back to its previous replica count, or exiting its window
+func (h *ObjectHandler) scheduleRestore(record *scheduleRecord) {
+	restoreAt := record.RestoreAt.Time
+	h.log.Info(trace(fmt.Sprintf("object <%s> restore at <%s>", h.fullname, restoreAt)))
+	h.setExpiresAt(restoreAt)
+	h.schedule("restore", restoreAt, func() {
+		h.restore(record)
+	})
+}
+
+// restore undoes the action and records the result: the object is scaled back to its previous replica count,
+// or the exit patch is applied when its window closes
+func (h *ObjectHandler) restore(record *scheduleRecord) {
+	if h.stopped() {
+		h.log.Info(trace(fmt.Sprintf("h aborted for object<%s>", h.fullname)))
+		return
+	}
+
+	subject, succeeded := h.exitMessages(record)
+	h.log.Info(trace(fmt.Sprintf("restoring object <%s>, %s...", h.fullname, subject)))
+	start := time.Now()
+	err := h.performObjectExit(record)
+	h.observeAction(actionRestore, start, err)
+	record.RestoreAttempts++
+	h.setAttempts(record.RestoreAttempts)
+	if err != nil {
+		h.log.Error(err, trace(fmt.Sprintf("object <%s> restore failed", h.fullname)))
+		h.setActionResult(v1alpha1.ActionResultFailed, err)
+		if retryAt, ok := h.nextRetry(record.RestoreAttempts, err); ok {
+			h.recordEvent(corev1.EventTypeWarning, reasonRestoreFailed, fmt.Sprintf("%s failed (attempt %d), retrying at %s: %s",
+				subject, record.RestoreAttempts, retryAt.Format(time.RFC3339), err))
+			record.RestoreAt = &metav1.Time{Time: retryAt}
+			h.saveScheduleRecord(record)
+			h.scheduleRetry(actionRestore, "restore", retryAt, func() {
+				h.restore(record)
+			})
+		} else {
+			h.recordEvent(corev1.EventTypeWarning, reasonRestoreFailed, fmt.Sprintf("%s failed after %d attempts: %s", subject, record.RestoreAttempts, err))
+			h.saveScheduleRecord(record)
+			h.rearm(record)
+		}
+		return
+	}
+	h.log.Info(trace(fmt.Sprintf("object <%s> restore finished", h.fullname)))
+	h.setActionResult(v1alpha1.ActionResultSucceeded, nil)
+	h.recordEvent(corev1.EventTypeNormal, reasonRestored, succeeded)
+
+	restoredAt := metav1.Now()
+	record.RestoredAt = &restoredAt
+	h.saveScheduleRecord(record)
+	h.rearm(record)
+}
+
+// Stop will be called, When the ObjectHandler requires to stop. It cancels the tasks of the object, and may be called more than once.
 func (h *ObjectHandler) Stop() {
+	owner := h.taskKey("").Owner
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.state == handlerStopped {
+		return
+	}
+	h.state = handlerStopped
 	close(h.stopper)
+	h.scheduler.CancelOwner(owner)
+}
+
+// stopped returns true once the ObjectHandler is stopped
+func (h *ObjectHandler) stopped() bool {
+	select {
+	case <-h.stopper:
+		return true
+	default:
+		return false
+	}
 }
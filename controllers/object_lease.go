@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Annotations set by the owners of a managed object to extend its expiration
+const (
+	// snoozeUntilAnnotation is an RFC3339 timestamp the expiration is pushed back to (ex: "2026-11-01T18:00:00Z")
+	snoozeUntilAnnotation = "resource-management.tikalk.com/snooze-until"
+	// leaseRenewedAtAnnotation is an RFC3339 timestamp the expiration of the resource manager is counted again from
+	leaseRenewedAtAnnotation = "resource-management.tikalk.com/lease-renewed-at"
+)
+
+// leaseAnnotations are the annotations that extend the expiration
+var leaseAnnotations = []string{snoozeUntilAnnotation, leaseRenewedAtAnnotation}
+
+// objectLease is the extension of the expiration of a single object, requested by its annotations
+type objectLease struct {
+	// value describes the lease annotations, a new value is a new extension request
+	value string
+
+	snoozeUntil time.Time
+	renewedAt   time.Time
+}
+
+// String describes the lease as recorded in the schedule, ex: "snooze-until=2026-11-01T18:00:00Z"
+func (l *objectLease) String() string {
+	if l == nil {
+		return ""
+	}
+	return l.value
+}
+
+// parseLease returns the lease of the object, or nil when the object is not annotated
+func parseLease(obj interface{}) (*objectLease, error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, err
+	}
+	annotations := accessor.GetAnnotations()
+
+	lease := &objectLease{}
+	var values []string
+	for _, annotation := range leaseAnnotations {
+		value, ok := annotations[annotation]
+		if !ok {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s annotation <%s>: %w", annotation, value, err)
+		}
+		if annotation == snoozeUntilAnnotation {
+			lease.snoozeUntil = t
+		} else {
+			lease.renewedAt = t
+		}
+		values = append(values, fmt.Sprintf("%s=%s", strings.TrimPrefix(annotation, "resource-management.tikalk.com/"), value))
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+	lease.value = strings.Join(values, ",")
+	return lease, nil
+}
+
+// leaseDueTime calculates the expiration requested by the lease, a renewed lease counts the expiration again from its renewal
+func (h *ObjectHandler) leaseDueTime(lease *objectLease, override *objectOverride) (time.Time, error) {
+	dueAt := lease.snoozeUntil
+	if !lease.renewedAt.IsZero() {
+		var renewedDueAt time.Time
+		if override != nil && override.annotation == expireAfterAnnotation {
+			renewedDueAt = lease.renewedAt.Add(override.expireAfter)
+		} else {
+			var err error
+			renewedDueAt, err = h.calculateDueTime(h.getResourceManager().GetSpec().Condition, lease.renewedAt, lease.renewedAt)
+			if err != nil {
+				return time.Time{}, err
+			}
+		}
+		if renewedDueAt.After(dueAt) {
+			dueAt = renewedDueAt
+		}
+	}
+	return dueAt, nil
+}
+
+// extendLease pushes back the expiration of the record when the lease of the object changed, within the limits of the resource manager.
+// It returns true when the record changed, and an error when the extension was rejected.
+func (h *ObjectHandler) extendLease(record *scheduleRecord, lease *objectLease, override *objectOverride) (bool, error) {
+	if record.Lease == lease.String() {
+		return false, nil
+	}
+	// a removed lease does not shorten the expiration
+	record.Lease = lease.String()
+	if lease == nil {
+		return true, nil
+	}
+
+	dueAt, err := h.leaseDueTime(lease, override)
+	if err != nil {
+		return true, err
+	}
+	if !dueAt.After(record.DueAt.Time) {
+		h.log.Info(trace(fmt.Sprintf("object <%s> lease <%s> does not extend the expiration <%s>", h.fullname, lease, record.DueAt)))
+		return true, nil
+	}
+
+	if policy := h.getResourceManager().GetSpec().Lease; policy != nil {
+		if policy.MaxExtensions != nil && record.Extensions >= *policy.MaxExtensions {
+			return true, fmt.Errorf("lease extension rejected: the maximum of %d extensions was reached", *policy.MaxExtensions)
+		}
+		latest, err := h.latestExpiration()
+		if err != nil {
+			return true, err
+		}
+		if !latest.IsZero() {
+			if dueAt.After(latest) {
+				h.log.Info(trace(fmt.Sprintf("object <%s> lease <%s> shortened to the maximum lifetime <%s>", h.fullname, lease, latest)))
+				dueAt = latest
+			}
+			if !dueAt.After(record.DueAt.Time) {
+				return true, fmt.Errorf("lease extension rejected: the maximum lifetime of %s was reached", policy.MaxLifetime)
+			}
+		}
+	}
+
+	h.log.Info(trace(fmt.Sprintf("object <%s> expiration extended from <%s> to <%s> by lease <%s>", h.fullname, record.DueAt, dueAt, lease)))
+	record.DueAt = metav1.NewTime(dueAt)
+	record.Extensions++
+	return true, nil
+}
+
+// latestExpiration returns the latest expiration of the object allowed by the maxLifetime of the lease policy,
+// or the zero time when the lifetime of the object is not limited
+func (h *ObjectHandler) latestExpiration() (time.Time, error) {
+	policy := h.getResourceManager().GetSpec().Lease
+	if policy == nil || policy.MaxLifetime == "" {
+		return time.Time{}, nil
+	}
+	maxLifetime, err := time.ParseDuration(policy.MaxLifetime)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid lease maxLifetime <%s>: %w", policy.MaxLifetime, err)
+	}
+	return h.creationTime.Add(maxLifetime), nil
+}
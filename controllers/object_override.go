@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+)
+
+// Annotations set by the owners of a managed object to override the expiration of the resource manager
+const (
+	// ignoreAnnotation set to "true" protects the object from the action
+	ignoreAnnotation = "resource-management.tikalk.com/ignore"
+	// expireAfterAnnotation is a duration from the creation of the object (ex: "48h")
+	expireAfterAnnotation = "resource-management.tikalk.com/expire-after"
+	// expireAtAnnotation is an RFC3339 timestamp (ex: "2026-11-01T18:00:00Z")
+	expireAtAnnotation = "resource-management.tikalk.com/expire-at"
+)
+
+// overrideAnnotations are the annotations that override the expiration, in the order of precedence
+var overrideAnnotations = []string{ignoreAnnotation, expireAtAnnotation, expireAfterAnnotation}
+
+// objectOverride is the expiration of a single object, set by its annotations
+type objectOverride struct {
+	// annotation is the name of the override annotation and value its value
+	annotation string
+	value      string
+
+	ignore      bool
+	expireAfter time.Duration
+	expireAt    time.Time
+}
+
+// String describes the override as reported in the status, ex: "expire-after=48h"
+func (o *objectOverride) String() string {
+	if o == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s=%s", strings.TrimPrefix(o.annotation, "resource-management.tikalk.com/"), o.value)
+}
+
+// parseOverride returns the override of the object, or nil when the object is not annotated.
+// When several override annotations are set, 'ignore' takes precedence over 'expire-at', which takes precedence over 'expire-after'.
+func parseOverride(obj interface{}) (*objectOverride, error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, err
+	}
+	annotations := accessor.GetAnnotations()
+
+	for _, annotation := range overrideAnnotations {
+		value, ok := annotations[annotation]
+		if !ok {
+			continue
+		}
+		override := &objectOverride{annotation: annotation, value: value}
+		switch annotation {
+		case ignoreAnnotation:
+			ignore, err := strconv.ParseBool(value)
+			if err != nil {
+				return override, fmt.Errorf("invalid %s annotation <%s>: %w", annotation, value, err)
+			}
+			if !ignore {
+				// an object that is explicitly not ignored may still have its expiration overridden
+				continue
+			}
+			override.ignore = true
+		case expireAtAnnotation:
+			if override.expireAt, err = time.Parse(time.RFC3339, value); err != nil {
+				return override, fmt.Errorf("invalid %s annotation <%s>: %w", annotation, value, err)
+			}
+		case expireAfterAnnotation:
+			if override.expireAfter, err = time.ParseDuration(value); err != nil {
+				return override, fmt.Errorf("invalid %s annotation <%s>: %w", annotation, value, err)
+			}
+		}
+		return override, nil
+	}
+	return nil, nil
+}
+
+// overrideChanged returns true when the override or lease annotations of the object changed
+func overrideChanged(oldObj interface{}, newObj interface{}) bool {
+	oldAccessor, err := meta.Accessor(oldObj)
+	if err != nil {
+		return true
+	}
+	newAccessor, err := meta.Accessor(newObj)
+	if err != nil {
+		return true
+	}
+	for _, annotation := range append(overrideAnnotations, leaseAnnotations...) {
+		oldValue, oldOk := oldAccessor.GetAnnotations()[annotation]
+		newValue, newOk := newAccessor.GetAnnotations()[annotation]
+		if oldOk != newOk || oldValue != newValue {
+			return true
+		}
+	}
+	return false
+}
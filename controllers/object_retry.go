@@ -0,0 +1,45 @@
+package controllers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/tikalk/resource-manager/controllers/utils"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// nextRetry returns the time of the retry that follows the failed attempt, false when the attempts are exhausted.
+// An object that no longer exists is not retried.
+func (h *ObjectHandler) nextRetry(attempts int32, err error) (time.Time, bool) {
+	if apierrors.IsNotFound(err) {
+		return time.Time{}, false
+	}
+	maxAttempts, backoff, maxBackoff, policyErr := h.getResourceManager().GetSpec().Retry.Limits()
+	if policyErr != nil || attempts >= maxAttempts {
+		return time.Time{}, false
+	}
+	return time.Now().Add(utils.Backoff(backoff, maxBackoff, attempts)), true
+}
+
+// attemptsExhausted returns true when a failed action was attempted the maximum number of times
+func (h *ObjectHandler) attemptsExhausted(attempts int32) bool {
+	maxAttempts, _, _, err := h.getResourceManager().GetSpec().Retry.Limits()
+	return err != nil || attempts >= maxAttempts
+}
+
+// setAttempts records the number of attempts of the last action
+func (h *ObjectHandler) setAttempts(attempts int32) {
+	h.mu.Lock()
+	h.attempts = attempts
+	h.mu.Unlock()
+	h.notify()
+}
+
+// scheduleRetry schedules the retry of a failed action, the retry is reported as the due time of the object
+func (h *ObjectHandler) scheduleRetry(action string, task string, retryAt time.Time, run func()) {
+	h.log.Info(trace(fmt.Sprintf("object <%s> action <%s> retry at <%s>", h.fullname, action, retryAt)))
+	actionRetries.WithLabelValues(resourceManagerLabel(client.ObjectKeyFromObject(h.getResourceManager())), action).Inc()
+	h.setExpiresAt(retryAt)
+	h.schedule(task, retryAt, run)
+}
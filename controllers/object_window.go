@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/tikalk/resource-manager/api/v1alpha1"
+	"github.com/tikalk/resource-manager/controllers/utils"
+)
+
+// inWindow returns true when the window of the condition is open at now
+func inWindow(cond v1alpha1.Expiration, now time.Time) (bool, error) {
+	location, err := cond.Location()
+	if err != nil {
+		return false, fmt.Errorf("cannot load time zone <%s>: %w", cond.TimeZone, err)
+	}
+	return utils.InWindow(now.In(location), cond.Window.Start, cond.Window.End)
+}
+
+// nextWindowStart returns the time the window of the condition opens next after now
+func nextWindowStart(cond v1alpha1.Expiration, now time.Time) (time.Time, error) {
+	location, err := cond.Location()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("cannot load time zone <%s>: %w", cond.TimeZone, err)
+	}
+	return utils.NextScheduleTime(now.In(location), cond.Window.Start)
+}
+
+// calculateRestoreTime calculates the time the action performed at executedAt is undone: when the window of the record closes,
+// or when the scale action restores the objects. It returns nil when the action is not undone.
+func (h *ObjectHandler) calculateRestoreTime(record *scheduleRecord, executedAt time.Time) (*time.Time, error) {
+	if window := record.Expiration.Window; window != nil {
+		location, err := record.Expiration.Location()
+		if err != nil {
+			return nil, fmt.Errorf("cannot load time zone <%s>: %w", record.Expiration.TimeZone, err)
+		}
+		restoreAt, err := utils.NextScheduleTime(executedAt.In(location), window.End)
+		if err != nil {
+			return nil, err
+		}
+		h.log.Info(trace(fmt.Sprintf("object <%s> window closes at <%s>", h.fullname, restoreAt)))
+		return &restoreAt, nil
+	}
+	if restore := scaleRestore(h.getResourceManager()); restore != nil {
+		restoreAt, err := h.calculateDueTime(*restore, executedAt, executedAt)
+		if err != nil {
+			return nil, err
+		}
+		return &restoreAt, nil
+	}
+	return nil, nil
+}
+
+// performObjectExit undoes the action of the record: the exit patch is applied when the window of a patch closes,
+// otherwise the scaled object is scaled back to its previous replicas
+func (h *ObjectHandler) performObjectExit(record *scheduleRecord) error {
+	window := record.Expiration.Window
+	if window == nil || h.getResourceManager().GetSpec().Action != v1alpha1.ActionPatch {
+		return h.performObjectRestore()
+	}
+
+	if _, err := h.patchObject(window.ExitActionParam); err != nil {
+		return err
+	}
+	// the object left the state of the window, the next window records its own revert patch
+	if h.getResourceManager().GetSpec().OnDelete == v1alpha1.OnDeleteRevert {
+		return h.patchAnnotation(revertAnnotation(h.getResourceManager()), nil)
+	}
+	return nil
+}
+
+// exitMessages returns the subject of the failure events and the message of the success event of undoing the action of the record
+func (h *ObjectHandler) exitMessages(record *scheduleRecord) (subject string, succeeded string) {
+	if record.Expiration.Window != nil && h.getResourceManager().GetSpec().Action == v1alpha1.ActionPatch {
+		return "the exit patch", "the exit patch was applied, the window closed"
+	}
+	return "the replicas restore", "the replicas were restored"
+}
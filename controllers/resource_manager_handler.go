@@ -3,116 +3,638 @@ package controllers
 import (
 	"errors"
 	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-logr/logr"
 	v1alpha1 "github.com/tikalk/resource-manager/api/v1alpha1"
+	"github.com/tikalk/resource-manager/controllers/notifier"
+	"github.com/tikalk/resource-manager/controllers/scheduler"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/client-go/informers"
-	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	//"reflect"
 )
 
+// maxTrackedObjects bounds the number of objects listed in the ResourceManager status
+const maxTrackedObjects = 20
+
 type ResourceManagerHandler struct {
-	resourceManager *v1alpha1.ResourceManager
-	namespaceName   string
-	objectsInformer cache.SharedIndexInformer
+	// resourceManagerLock guards resourceManager and sender, which are replaced by spec updates
+	resourceManagerLock sync.RWMutex
+	resourceManager     v1alpha1.ResourceManagerObject
+	namespaceName       string
+	objectsInformer     cache.SharedIndexInformer
+	// objHandlersLock guards the registries of the object handlers and the lifecycle state of the handler,
+	// they are updated by the informers callbacks, the reconciler and the status updates
+	objHandlersLock sync.RWMutex
 	objHandlers     map[types.NamespacedName]*ObjectHandler
-	stopper         chan struct{}
-	clientset       *kubernetes.Clientset
-	log             logr.Logger
+	// state is the lifecycle state of the handler, a stopped handler registers no object handlers
+	state handlerState
+	// adopted holds the object handlers taken over from the replaced handler of the resource manager, until the objects are listed
+	adopted map[types.NamespacedName]*ObjectHandler
+	// rescheduleAdopted is true when the schedule of the adopted objects changed
+	rescheduleAdopted bool
+	// completed holds the UIDs of the objects whose handler completed (ex: the object was deleted by the action),
+	// until their delete event, so the events that precede it do not start handling the objects again
+	completed map[types.NamespacedName]types.UID
+	// finalizing is true when the handler lists the objects of a deleted resource manager, their actions are not scheduled.
+	// It is guarded by objHandlersLock, as it is read by the informers callbacks.
+	finalizing bool
+	// namespaces filters the objects of namespaced kinds across namespaces, nil when only a single namespace is watched
+	namespaces     *namespaceFilter
+	stopper        chan struct{}
+	resourceClient dynamic.NamespaceableResourceInterface
+	scheduler      *scheduler.Scheduler
+	recorder       record.EventRecorder
+	sender         *notificationSender
+	// changed coalesces the notifications about tracked state changes
+	changed chan struct{}
+	// onChange is called (from a single goroutine) after the tracked state changed
+	onChange func()
+	log      logr.Logger
 }
 
 // NewResourceManagerHandler registers a resource-specific Resource Manager handler and acts according to it's values.
-func NewResourceManagerHandler(resourceManager *v1alpha1.ResourceManager, clientset *kubernetes.Clientset, log logr.Logger) (*ResourceManagerHandler, error) {
-	selector, _ := metav1.LabelSelectorAsSelector(resourceManager.Spec.Selector)
-	labelOptions := informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
-		opts.LabelSelector = selector.String()
-	})
+// onChange is called whenever the state reported in the ResourceManager status changes.
+// The handler keeps a copy of the resource manager, the reconciler keeps decoding the API responses into its own object.
+func NewResourceManagerHandler(resourceManager v1alpha1.ResourceManagerObject, dynamicClient dynamic.Interface, mapper meta.RESTMapper, taskScheduler *scheduler.Scheduler, recorder record.EventRecorder, webhookNotifier *notifier.Notifier, onChange func(), log logr.Logger) (*ResourceManagerHandler, error) {
+	resourceManager = resourceManager.DeepCopyObject().(v1alpha1.ResourceManagerObject)
+	selector, err := metav1.LabelSelectorAsSelector(resourceManager.GetSpec().Selector)
+	if err != nil {
+		return nil, err
+	}
+
+	mapping, err := resolveResourceMapping(mapper, resourceManager.GetSpec().ResourceAPIVersion, resourceManager.GetSpec().ResourceKind)
+	if err != nil {
+		return nil, err
+	}
 
-	factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0, informers.WithNamespace(resourceManager.Namespace), labelOptions)
+	// cluster-scoped kinds (ex: Namespace) cannot be listed inside a namespace
+	namespace := metav1.NamespaceAll
+	var namespaces *namespaceFilter
+	switch {
+	case mapping.Scope.Name() != meta.RESTScopeNameNamespace:
+		if resourceManager.IsNamespaced() {
+			return nil, fmt.Errorf("the cluster-scoped kind <%s> is managed by a ClusterResourceManager", mapping.GroupVersionKind.Kind)
+		}
+	case resourceManager.IsNamespaced():
+		// a namespaced resource manager manages the objects of its own namespace only
+		namespace = resourceManager.GetNamespace()
+	default:
+		namespaces, err = newNamespaceFilter(resourceManager, dynamicClient)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	objectsInformer, err := createObjectsInformer(factory, resourceManager.Spec.ResourceKind)
+	sender, err := newNotificationSender(resourceManager, dynamicClient, webhookNotifier, log)
 	if err != nil {
 		return nil, err
 	}
 
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(dynamicClient, 0, namespace, func(opts *metav1.ListOptions) {
+		opts.LabelSelector = selector.String()
+	})
+
 	return &ResourceManagerHandler{
 		resourceManager: resourceManager,
-		objectsInformer: objectsInformer,
+		namespaceName:   namespace,
+		objectsInformer: factory.ForResource(mapping.Resource).Informer(),
 		objHandlers:     make(map[types.NamespacedName]*ObjectHandler),
+		completed:       make(map[types.NamespacedName]types.UID),
+		namespaces:      namespaces,
 		stopper:         make(chan struct{}),
-		clientset:       clientset,
+		resourceClient:  dynamicClient.Resource(mapping.Resource),
+		scheduler:       taskScheduler,
+		recorder:        recorder,
+		sender:          sender,
+		changed:         make(chan struct{}, 1),
+		onChange:        onChange,
 		log:             log,
 	}, nil
 }
 
-// createObjectsInformer creates an object informer (Deployment or Namespace) for the relevant object.
-func createObjectsInformer(factory informers.SharedInformerFactory, kind string) (informer cache.SharedIndexInformer, err error) {
-	switch kind {
-	case "Deployment":
-		informer = factory.Apps().V1().Deployments().Informer()
-	case "Namespace":
-		informer = factory.Core().V1().Namespaces().Informer()
-	default:
-		err = fmt.Errorf("invalid kind %s when getting an informer", kind)
+// resolveResourceMapping finds the REST mapping (resource and scope) of the managed kind.
+// When apiVersion is empty the kind is looked up in all the groups served by the cluster.
+func resolveResourceMapping(mapper meta.RESTMapper, apiVersion string, kind string) (*meta.RESTMapping, error) {
+	if apiVersion != "" {
+		gv, err := schema.ParseGroupVersion(apiVersion)
+		if err != nil {
+			return nil, fmt.Errorf("invalid apiVersion <%s>: %w", apiVersion, err)
+		}
+		return mapper.RESTMapping(gv.WithKind(kind).GroupKind(), gv.Version)
 	}
-	return informer, err
+
+	gvk, err := mapper.KindFor(schema.GroupVersionResource{Resource: strings.ToLower(kind)})
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve kind <%s>: %w", kind, err)
+	}
+	return mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
 }
 
-// addObjHandler add ObjectHandler to collection if not exists
-func (h *ResourceManagerHandler) addObjHandler(objHandler *ObjectHandler) {
+// addObjHandler add ObjectHandler to collection if not exists, it returns false when it already exists or the handler is stopped
+func (h *ResourceManagerHandler) addObjHandler(objHandler *ObjectHandler) bool {
+	h.objHandlersLock.Lock()
+	defer h.objHandlersLock.Unlock()
+	if h.state == handlerStopped {
+		h.log.Info(trace(fmt.Sprintf("object handler <%s> not registered, the handler is <%s>.", objHandler.fullname, h.state)))
+		return false
+	}
 	if _, ok := h.objHandlers[objHandler.fullname]; ok {
 		h.log.Error(errors.New("addObjHandler failed"), trace(fmt.Sprintf("object handler already registered <%s>.", objHandler.fullname)))
-		return
+		return false
 	}
 
 	h.objHandlers[objHandler.fullname] = objHandler
+	h.markChanged()
+	return true
+}
+
+// getResourceManager returns the resource manager and the sender of its notifications
+func (h *ResourceManagerHandler) getResourceManager() (v1alpha1.ResourceManagerObject, *notificationSender) {
+	h.resourceManagerLock.RLock()
+	defer h.resourceManagerLock.RUnlock()
+	return h.resourceManager, h.sender
+}
+
+// Update applies a spec update that keeps the managed objects (ex: the action changed), without listing the objects again.
+// The objects are rescheduled only when their schedule changed, and they keep the deadlines persisted on them.
+// As NewResourceManagerHandler, it keeps a copy of the resource manager.
+func (h *ResourceManagerHandler) Update(resourceManager v1alpha1.ResourceManagerObject, sender *notificationSender) {
+	resourceManager = resourceManager.DeepCopyObject().(v1alpha1.ResourceManagerObject)
+	previous, _ := h.getResourceManager()
+	reschedule := scheduleChanged(previous.GetSpec(), resourceManager.GetSpec())
+	h.resourceManagerLock.Lock()
+	h.resourceManager = resourceManager
+	h.sender = sender
+	h.resourceManagerLock.Unlock()
+
+	h.objHandlersLock.RLock()
+	defer h.objHandlersLock.RUnlock()
+	for _, objHandler := range h.objHandlers {
+		objHandler.reconfigure(resourceManager, sender, h, reschedule)
+	}
+	h.markChanged()
 }
 
-// removeObjHandelr , If necessary, removes the ObjectHandler from the collection
-func (h *ResourceManagerHandler) removeObjHandelr(fullname types.NamespacedName) {
-	if _, ok := h.objHandlers[fullname]; !ok {
-		h.log.Error(errors.New("removeObjHandelr failed"), trace(fmt.Sprintf("object handler removing failed <%s>.", fullname)))
+// sameKind returns true when both specs manage the same kind
+func sameKind(previous *v1alpha1.ResourceManagerSpec, spec *v1alpha1.ResourceManagerSpec) bool {
+	return previous.ResourceAPIVersion == spec.ResourceAPIVersion && previous.ResourceKind == spec.ResourceKind
+}
+
+// sameObjects returns true when both specs select the same objects
+func sameObjects(previous *v1alpha1.ResourceManagerSpec, spec *v1alpha1.ResourceManagerSpec) bool {
+	return sameKind(previous, spec) &&
+		reflect.DeepEqual(previous.Selector, spec.Selector) &&
+		reflect.DeepEqual(previous.NamespaceSelector, spec.NamespaceSelector) &&
+		reflect.DeepEqual(previous.Namespaces, spec.Namespaces)
+}
+
+// scheduleChanged returns true when the objects must be rescheduled after the spec update
+func scheduleChanged(previous *v1alpha1.ResourceManagerSpec, spec *v1alpha1.ResourceManagerSpec) bool {
+	var previousRestore, restore *v1alpha1.Expiration
+	if previous.Scale != nil {
+		previousRestore = previous.Scale.Restore
+	}
+	if spec.Scale != nil {
+		restore = spec.Scale.Restore
+	}
+	return !reflect.DeepEqual(previous.Condition, spec.Condition) ||
+		previous.Recurs() != spec.Recurs() ||
+		!reflect.DeepEqual(previous.WarnBefore, spec.WarnBefore) ||
+		!reflect.DeepEqual(previous.Lease, spec.Lease) ||
+		!reflect.DeepEqual(previous.Retry, spec.Retry) ||
+		!reflect.DeepEqual(previousRestore, restore)
+}
+
+// release stops watching the objects and returns the object handlers, which keep running, to the handler replacing this one.
+// A stopped handler has no object handlers to return.
+func (h *ResourceManagerHandler) release() map[types.NamespacedName]*ObjectHandler {
+	h.objHandlersLock.Lock()
+	defer h.objHandlersLock.Unlock()
+	if h.state == handlerStopped {
+		return nil
+	}
+	h.state = handlerStopped
+	close(h.stopper)
+
+	objHandlers := h.objHandlers
+	for fullname, objHandler := range h.adopted {
+		objHandlers[fullname] = objHandler
+	}
+	h.objHandlers = make(map[types.NamespacedName]*ObjectHandler)
+	h.adopted = nil
+	return objHandlers
+}
+
+// adopt takes over the object handlers of the replaced handler of the resource manager, which managed the same kind.
+// The objects that still match keep their schedules, the others are stopped once the objects are listed.
+func (h *ResourceManagerHandler) adopt(objHandlers map[types.NamespacedName]*ObjectHandler, reschedule bool) {
+	h.objHandlersLock.Lock()
+	defer h.objHandlersLock.Unlock()
+	if h.state == handlerStopped {
+		for _, objHandler := range objHandlers {
+			objHandler.Stop()
+		}
 		return
 	}
-	h.objHandlers[fullname].Stop()
+	h.adopted = objHandlers
+	h.rescheduleAdopted = reschedule
+}
+
+// takeAdopted returns the adopted handler of the object, or nil when the object was not handled by the replaced handler
+func (h *ResourceManagerHandler) takeAdopted(obj interface{}) *ObjectHandler {
+	fullname, err := extractFullname(obj)
+	if err != nil {
+		return nil
+	}
+
+	h.objHandlersLock.Lock()
+	defer h.objHandlersLock.Unlock()
+	objHandler, ok := h.adopted[fullname]
+	if !ok {
+		return nil
+	}
+	delete(h.adopted, fullname)
+	if objHandler.UID() != extractUID(obj) || objHandler.stopped() {
+		// the object was recreated with the same name, or its handler completed before it was handed over
+		objHandler.Stop()
+		return nil
+	}
+	return objHandler
+}
+
+// dropAdopted stops the adopted handlers of the objects that no longer match
+func (h *ResourceManagerHandler) dropAdopted() {
+	h.objHandlersLock.Lock()
+	defer h.objHandlersLock.Unlock()
+	for fullname, objHandler := range h.adopted {
+		h.log.Info(trace(fmt.Sprintf("Deleting adopted object handler: <%s>", fullname)))
+		objHandler.Stop()
+	}
+	h.adopted = nil
+}
+
+// hasObjHandler returns true when the object is handled
+func (h *ResourceManagerHandler) hasObjHandler(fullname types.NamespacedName) bool {
+	return h.getObjHandler(fullname) != nil
+}
+
+// getObjHandler returns the ObjectHandler of the object, or nil when the object is not handled
+func (h *ResourceManagerHandler) getObjHandler(fullname types.NamespacedName) *ObjectHandler {
+	h.objHandlersLock.RLock()
+	defer h.objHandlersLock.RUnlock()
+	return h.objHandlers[fullname]
+}
+
+// removeObjHandelr , If necessary, removes the ObjectHandler from the collection and stops it.
+// It returns false when the object is not handled, ex: it was removed by a concurrent event.
+func (h *ResourceManagerHandler) removeObjHandelr(fullname types.NamespacedName) bool {
+	h.objHandlersLock.Lock()
+	objHandler, ok := h.objHandlers[fullname]
 	delete(h.objHandlers, fullname)
+	h.objHandlersLock.Unlock()
+	if !ok {
+		return false
+	}
+
+	objHandler.Stop()
+	h.markChanged()
+	return true
+}
+
+// objectDone deregisters and stops a completed object handler, the object is not handled again until it is deleted.
+// A handler that was replaced (ex: the object was recreated) is stopped without touching its replacement.
+func (h *ResourceManagerHandler) objectDone(objHandler *ObjectHandler) {
+	h.objHandlersLock.Lock()
+	if h.state != handlerStopped {
+		if h.objHandlers[objHandler.fullname] == objHandler {
+			delete(h.objHandlers, objHandler.fullname)
+		}
+		h.completed[objHandler.fullname] = objHandler.UID()
+	}
+	h.objHandlersLock.Unlock()
+
+	objHandler.Stop()
+	h.markChanged()
+}
+
+// isCompleted returns true when the handler of the object completed, and the object was not deleted yet
+func (h *ResourceManagerHandler) isCompleted(obj interface{}) bool {
+	fullname, err := extractFullname(obj)
+	if err != nil {
+		return false
+	}
+	h.objHandlersLock.RLock()
+	defer h.objHandlersLock.RUnlock()
+	uid, ok := h.completed[fullname]
+	return ok && uid == extractUID(obj)
+}
+
+// forgetCompleted forgets the completed handler of a deleted object
+func (h *ResourceManagerHandler) forgetCompleted(fullname types.NamespacedName) {
+	h.objHandlersLock.Lock()
+	defer h.objHandlersLock.Unlock()
+	delete(h.completed, fullname)
+}
+
+// setFinalizing marks the handler as listing the objects of a deleted resource manager, it is set before the handler runs
+func (h *ResourceManagerHandler) setFinalizing() {
+	h.objHandlersLock.Lock()
+	defer h.objHandlersLock.Unlock()
+	h.finalizing = true
+}
+
+// isFinalizing returns true when the handler lists the objects of a deleted resource manager
+func (h *ResourceManagerHandler) isFinalizing() bool {
+	h.objHandlersLock.RLock()
+	defer h.objHandlersLock.RUnlock()
+	return h.finalizing
+}
+
+// markChanged signals that the tracked state changed, without blocking the caller
+func (h *ResourceManagerHandler) markChanged() {
+	select {
+	case h.changed <- struct{}{}:
+	default:
+	}
 }
 
-// Run start listening to new objects
+// notifyChanges calls onChange for the signaled changes until the handler is stopped
+func (h *ResourceManagerHandler) notifyChanges() {
+	for {
+		select {
+		case <-h.stopper:
+			return
+		case <-h.changed:
+			h.onChange()
+		}
+	}
+}
+
+// HasSynced returns true once the initial list of objects is handled
+func (h *ResourceManagerHandler) HasSynced() bool {
+	return (h.namespaces == nil || h.namespaces.HasSynced()) && h.objectsInformer.HasSynced()
+}
+
+// matchesNamespace returns true when the objects in the namespace are managed
+func (h *ResourceManagerHandler) matchesNamespace(namespace string) bool {
+	return h.namespaces == nil || h.namespaces.Matches(namespace)
+}
+
+// isEligible returns true when the action should be scheduled for the object, otherwise the reason it is not
+func (h *ResourceManagerHandler) isEligible(obj interface{}) (bool, string) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return false, err.Error()
+	}
+	if accessor.GetDeletionTimestamp() != nil {
+		return false, "the object is being deleted"
+	}
+	if !h.matchesNamespace(accessor.GetNamespace()) {
+		return false, "the namespace is not selected"
+	}
+	return true, ""
+}
+
+// addObject starts handling an object
+func (h *ResourceManagerHandler) addObject(obj interface{}) {
+	if eligible, _ := h.isEligible(obj); !eligible || h.isCompleted(obj) {
+		return
+	}
+	resourceManager, sender := h.getResourceManager()
+	if objectHandler := h.takeAdopted(obj); objectHandler != nil {
+		h.log.Info(trace(fmt.Sprintf("Adopting object handler: <%s>", objectHandler.fullname)))
+		if !h.addObjHandler(objectHandler) {
+			objectHandler.Stop()
+			return
+		}
+		objectHandler.Update(obj)
+		objectHandler.reconfigure(resourceManager, sender, h, h.rescheduleAdopted)
+		return
+	}
+	objectHandler, err := NewObjectHandler(resourceManager, obj, h.resourceClient, h.scheduler, h.recorder, sender, h, h.log)
+	if err != nil {
+		h.log.Error(err, fmt.Sprintf("NewObjectHandler handler creating failed with error <%s>.", err))
+		return
+	}
+	h.log.Info(trace(fmt.Sprintf("Adding object handler: <%s>", objectHandler.fullname)))
+	if h.addObjHandler(objectHandler) && !h.isFinalizing() {
+		objectHandler.Start()
+	}
+}
+
+// updateObject re-evaluates the eligibility of an updated object, and schedules or cancels its action accordingly.
+// The objects that stop matching the selector are reported as deleted by the informer.
+func (h *ResourceManagerHandler) updateObject(oldObj interface{}, newObj interface{}) {
+	if !relevantChange(oldObj, newObj) {
+		return
+	}
+	fullname, err := extractFullname(newObj)
+	if err != nil {
+		h.log.Error(err, fmt.Sprintf("Updated object name extracting failed with error <%s>.", err))
+		return
+	}
+
+	eligible, reason := h.isEligible(newObj)
+	objHandler := h.getObjHandler(fullname)
+	switch {
+	case !eligible && objHandler != nil:
+		h.log.Info(trace(fmt.Sprintf("Deleting object handler: <%s> %s", fullname, reason)))
+		h.removeObjHandelr(fullname)
+	case eligible && objHandler == nil:
+		h.addObject(newObj)
+	case eligible && objHandler.UID() != extractUID(newObj):
+		// the object was recreated with the same name
+		h.log.Info(trace(fmt.Sprintf("Replacing object handler: <%s>", fullname)))
+		h.removeObjHandelr(fullname)
+		h.addObject(newObj)
+	case eligible:
+		objHandler.Update(newObj)
+	}
+}
+
+// relevantChange returns false when an update changed nothing that affects the handling of the object,
+// ex: the annotations written by the handlers themselves
+func relevantChange(oldObj interface{}, newObj interface{}) bool {
+	oldAccessor, err := meta.Accessor(oldObj)
+	if err != nil {
+		return true
+	}
+	newAccessor, err := meta.Accessor(newObj)
+	if err != nil {
+		return true
+	}
+
+	if oldAccessor.GetUID() != newAccessor.GetUID() ||
+		!reflect.DeepEqual(oldAccessor.GetDeletionTimestamp(), newAccessor.GetDeletionTimestamp()) ||
+		!reflect.DeepEqual(oldAccessor.GetLabels(), newAccessor.GetLabels()) {
+		return true
+	}
+	return !reflect.DeepEqual(foreignAnnotations(oldAccessor.GetAnnotations()), foreignAnnotations(newAccessor.GetAnnotations()))
+}
+
+// foreignAnnotations returns the annotations that were not written by the handlers
+func foreignAnnotations(annotations map[string]string) map[string]string {
+	foreign := make(map[string]string, len(annotations))
+	for name, value := range annotations {
+		if strings.HasPrefix(name, scheduleAnnotationPrefix) || strings.HasPrefix(name, revertAnnotationPrefix) || name == previousReplicasAnnotation {
+			continue
+		}
+		foreign[name] = value
+	}
+	return foreign
+}
+
+// syncNamespace starts or stops handling the objects in a namespace that started or stopped matching the namespace selector
+func (h *ResourceManagerHandler) syncNamespace(namespace string) {
+	objs, err := h.objectsInformer.GetIndexer().ByIndex(cache.NamespaceIndex, namespace)
+	if err != nil {
+		h.log.Error(err, fmt.Sprintf("Listing the objects in namespace <%s> failed with error <%s>.", namespace, err))
+		return
+	}
+
+	matches := h.matchesNamespace(namespace)
+	h.log.Info(trace(fmt.Sprintf("Namespace <%s> changed, matches <%t>", namespace, matches)))
+	for _, obj := range objs {
+		fullname, err := extractFullname(obj)
+		if err != nil {
+			continue
+		}
+		handled := h.hasObjHandler(fullname)
+		if matches && !handled {
+			h.addObject(obj)
+		} else if !matches && handled {
+			h.log.Info(trace(fmt.Sprintf("Deleting object handler: <%s>", fullname)))
+			h.removeObjHandelr(fullname)
+		}
+	}
+}
+
+// TrackedObjects returns the number of matched objects and the objects with the nearest expiration
+func (h *ResourceManagerHandler) TrackedObjects() (matched int, tracked []v1alpha1.TrackedObject) {
+	h.objHandlersLock.RLock()
+	tracked = make([]v1alpha1.TrackedObject, 0, len(h.objHandlers))
+	for _, objHandler := range h.objHandlers {
+		tracked = append(tracked, objHandler.TrackedObject())
+	}
+	h.objHandlersLock.RUnlock()
+
+	// nearest expiration first, objects without expiration last
+	sort.Slice(tracked, func(i, j int) bool {
+		a, b := tracked[i], tracked[j]
+		if (a.ExpiresAt == nil) != (b.ExpiresAt == nil) {
+			return a.ExpiresAt != nil
+		}
+		if a.ExpiresAt != nil && !a.ExpiresAt.Equal(b.ExpiresAt) {
+			return a.ExpiresAt.Before(b.ExpiresAt)
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+
+	matched = len(tracked)
+	if len(tracked) > maxTrackedObjects {
+		tracked = tracked[:maxTrackedObjects]
+	}
+	return matched, tracked
+}
+
+// nextAction returns the number of matched objects and the time of the nearest pending action, zero when none is pending
+func (h *ResourceManagerHandler) nextAction() (matched int, nextActionAt time.Time) {
+	h.objHandlersLock.RLock()
+	defer h.objHandlersLock.RUnlock()
+
+	for _, objHandler := range h.objHandlers {
+		if dueAt := objHandler.pendingActionAt(); !dueAt.IsZero() && (nextActionAt.IsZero() || dueAt.Before(nextActionAt)) {
+			nextActionAt = dueAt
+		}
+	}
+	return len(h.objHandlers), nextActionAt
+}
+
+// Run start listening to new objects, a handler runs once and a stopped handler is not started
 func (h *ResourceManagerHandler) Run() error {
+	h.objHandlersLock.Lock()
+	if h.state != handlerCreated {
+		h.objHandlersLock.Unlock()
+		return nil
+	}
+	h.state = handlerRunning
+	h.objHandlersLock.Unlock()
 
 	h.objectsInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc: func(obj interface{}) {
-			objectHandler, err := NewObjectHandler(h.resourceManager, obj, h.clientset, h.log)
-			if err != nil {
-				h.log.Error(err, fmt.Sprintf("NewObjectHandler handler creating failed with error <%s>.", err))
-				return
-			}
-			h.log.Info(trace(fmt.Sprintf("Adding object handler: <%s>", objectHandler.fullname)))
-			h.addObjHandler(objectHandler)
-			go objectHandler.Run()
-		},
+		AddFunc:    h.addObject,
+		UpdateFunc: h.updateObject,
 		DeleteFunc: func(obj interface{}) {
-			objHandler, err := NewObjectHandler(h.resourceManager, obj, h.clientset, h.log)
+			// the object may be wrapped with a tombstone, if the delete event was missed
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			fullname, err := extractFullname(obj)
 			if err != nil {
-				h.log.Error(err, fmt.Sprintf("NewObjectHandler handler creating failed with error <%s>.", err))
+				h.log.Error(err, fmt.Sprintf("Deleted object name extracting failed with error <%s>.", err))
 				return
 			}
-			h.log.Info(trace(fmt.Sprintf("Deleting object handler: <%s>", objHandler.fullname)))
-			h.removeObjHandelr(objHandler.fullname)
+			h.forgetCompleted(fullname)
+			if h.removeObjHandelr(fullname) {
+				h.log.Info(trace(fmt.Sprintf("Deleted object handler: <%s>", fullname)))
+			}
 		},
 	})
+
+	// the namespaces are listed first, so the objects are filtered by the selected namespaces
+	if h.namespaces != nil {
+		h.namespaces.Run(h.stopper, h.syncNamespace)
+		if !cache.WaitForCacheSync(h.stopper, h.namespaces.HasSynced) {
+			return nil
+		}
+	}
+
 	// start the objectsInformer
 	go h.objectsInformer.Run(h.stopper)
 
+	go h.notifyChanges()
+	// report the handler readiness once the existing objects are listed
+	go func() {
+		if cache.WaitForCacheSync(h.stopper, h.objectsInformer.HasSynced) {
+			h.dropAdopted()
+			h.markChanged()
+		}
+	}()
+
 	return nil
 }
 
-// Stop abort the calculation of the expiration time.
+// Stop abort the calculation of the expiration time. It may be called more than once, and before the handler runs.
 func (h *ResourceManagerHandler) Stop() {
+	h.objHandlersLock.Lock()
+	defer h.objHandlersLock.Unlock()
+	if h.state == handlerStopped {
+		return
+	}
+	h.state = handlerStopped
 	close(h.stopper)
+
+	// cancel the scheduled tasks of all the objects
+	for _, objHandler := range h.objHandlers {
+		objHandler.Stop()
+	}
+	for _, objHandler := range h.adopted {
+		objHandler.Stop()
+	}
+	h.objHandlers = make(map[types.NamespacedName]*ObjectHandler)
+	h.adopted = nil
 }
@@ -0,0 +1,585 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	resourcemanagmentv1alpha1 "github.com/tikalk/resource-manager/api/v1alpha1"
+	"github.com/tikalk/resource-manager/controllers/scheduler"
+)
+
+// newFakeResourceManagerHandler returns a handler of the ConfigMaps of the default namespace, backed by a fake dynamic client
+func newFakeResourceManagerHandler(resourceManager *resourcemanagmentv1alpha1.ResourceManager, taskScheduler *scheduler.Scheduler, objs ...k8sruntime.Object) *ResourceManagerHandler {
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(k8sruntime.NewScheme(),
+		map[schema.GroupVersionResource]string{{Version: "v1", Resource: "configmaps"}: "ConfigMapList"}, objs...)
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, meta.RESTScopeNamespace)
+
+	handler, err := NewResourceManagerHandler(resourceManager, dynamicClient, mapper, taskScheduler, nil, nil, func() {}, logf.Log)
+	Expect(err).NotTo(HaveOccurred(), "failed to create handler")
+	return handler
+}
+
+// newFakeConfigMap returns a ConfigMap of the default namespace with the given name label
+func newFakeConfigMap(name string, label string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("v1")
+	obj.SetKind("ConfigMap")
+	obj.SetNamespace("default")
+	obj.SetName(name)
+	obj.SetUID(types.UID(name))
+	obj.SetLabels(map[string]string{"name": label})
+	obj.SetCreationTimestamp(metav1.Now())
+	return obj
+}
+
+var _ = Context("Inside of a ResourceManagerHandler", func() {
+	var taskScheduler *scheduler.Scheduler
+	var cancelFunc context.CancelFunc
+
+	// the registries are exercised without the API server, the suite is run with -race to detect unguarded accesses
+	BeforeEach(func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		actionScheduler := scheduler.New(scheduler.DefaultWorkers)
+		go func() {
+			_ = actionScheduler.Start(ctx)
+		}()
+		taskScheduler, cancelFunc = actionScheduler, cancel
+	})
+
+	AfterEach(func() {
+		cancelFunc()
+	})
+
+	newConcurrentResourceManager := func(name string) *resourcemanagmentv1alpha1.ResourceManager {
+		return &resourcemanagmentv1alpha1.ResourceManager{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "default",
+				UID:       types.UID(name),
+			},
+			Spec: resourcemanagmentv1alpha1.ResourceManagerSpec{
+				ResourceAPIVersion: "v1",
+				ResourceKind:       "ConfigMap",
+				Selector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{
+						"name": "concurrent-configmap",
+					},
+				},
+				Action: "delete",
+				Condition: resourcemanagmentv1alpha1.Expiration{
+					ExpireAfter: "1h",
+				},
+			},
+		}
+	}
+
+	// concurrently runs the functions and waits for them
+	concurrently := func(funcs ...func()) {
+		var wg sync.WaitGroup
+		for _, f := range funcs {
+			wg.Add(1)
+			go func(f func()) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				f()
+			}(f)
+		}
+		wg.Wait()
+	}
+
+	Describe("when objects and policies change concurrently", func() {
+		It("should schedule the action of every handled object once", func() {
+			const objects = 20
+			resourceManager := newConcurrentResourceManager("test-concurrent-resource-manager")
+			var configMaps []*unstructured.Unstructured
+			var trackerObjs []k8sruntime.Object
+			for i := 0; i < objects; i++ {
+				configMap := newFakeConfigMap(fmt.Sprintf("test-concurrent-configmap-%d", i), "concurrent-configmap")
+				configMaps = append(configMaps, configMap)
+				trackerObjs = append(trackerObjs, configMap.DeepCopy())
+			}
+			handler := newFakeResourceManagerHandler(resourceManager, taskScheduler, trackerObjs...)
+
+			var funcs []func()
+			for _, configMap := range configMaps {
+				configMap := configMap
+				relabeled := configMap.DeepCopy()
+				relabeled.SetLabels(map[string]string{"name": "concurrent-configmap", "tier": "test"})
+				funcs = append(funcs,
+					func() { handler.addObject(configMap) },
+					func() { handler.updateObject(configMap, relabeled) },
+					func() { handler.removeObjHandelr(client.ObjectKeyFromObject(configMap)) },
+					func() { handler.addObject(relabeled) },
+				)
+			}
+			for i := 1; i <= 5; i++ {
+				updated := resourceManager.DeepCopy()
+				updated.Spec.Condition.ExpireAfter = fmt.Sprintf("%dh", i)
+				funcs = append(funcs,
+					func() { handler.Update(updated, nil) },
+					func() { handler.TrackedObjects() },
+					func() { handler.nextAction() },
+				)
+			}
+			concurrently(funcs...)
+
+			// once the events settle every object is handled, and its action is scheduled once
+			for _, configMap := range configMaps {
+				if !handler.hasObjHandler(client.ObjectKeyFromObject(configMap)) {
+					handler.addObject(configMap)
+				}
+			}
+			Eventually(func() int {
+				matched, _ := handler.nextAction()
+				return matched
+			}, time.Second*5, time.Millisecond*100).Should(Equal(objects))
+			Eventually(taskScheduler.Len, time.Second*5, time.Millisecond*100).Should(Equal(objects))
+
+			// a stopped handler cancels its tasks, and the tasks that run meanwhile schedule no others
+			concurrently(handler.Stop, handler.Stop)
+			Eventually(taskScheduler.Len, time.Second*5, time.Millisecond*100).Should(BeZero())
+			handler.addObject(configMaps[0])
+			Consistently(taskScheduler.Len, time.Second, time.Millisecond*100).Should(BeZero())
+			matched, _ := handler.TrackedObjects()
+			Expect(matched).To(BeZero())
+		})
+
+		It("should not run a stopped handler", func() {
+			configMap := newFakeConfigMap("test-stopped-configmap", "concurrent-configmap")
+			handler := newFakeResourceManagerHandler(newConcurrentResourceManager("test-stopped-resource-manager"), taskScheduler, configMap.DeepCopy())
+
+			handler.Stop()
+			Expect(handler.Run()).To(Succeed())
+			handler.addObject(configMap)
+			Expect(handler.hasObjHandler(client.ObjectKeyFromObject(configMap))).To(BeFalse())
+			Expect(handler.release()).To(BeEmpty())
+		})
+	})
+
+	Describe("when object handlers complete", func() {
+		It("should deregister the handler of a deleted object and not act on it again", func() {
+			resourceManager := newConcurrentResourceManager("test-completed-resource-manager")
+			configMap := newFakeConfigMap("test-completed-configmap", "concurrent-configmap")
+			configMap.SetCreationTimestamp(metav1.NewTime(time.Now().Add(-2 * time.Hour)))
+			handler := newFakeResourceManagerHandler(resourceManager, taskScheduler, configMap.DeepCopy())
+			name := client.ObjectKeyFromObject(configMap)
+
+			handler.addObject(configMap)
+			Eventually(func() bool {
+				return handler.hasObjHandler(name)
+			}, time.Second*5, time.Millisecond*100).Should(BeFalse())
+			_, err := handler.resourceClient.Namespace(name.Namespace).Get(context.Background(), name.Name, metav1.GetOptions{})
+			Expect(apierrors.IsNotFound(err)).To(BeTrue(), "the object should be deleted")
+
+			// the events that precede the delete event do not start handling the object again
+			handler.addObject(configMap)
+			Expect(handler.hasObjHandler(name)).To(BeFalse())
+			Expect(taskScheduler.Len()).To(BeZero())
+
+			// a recreated object is handled again
+			recreated := configMap.DeepCopy()
+			recreated.SetUID("test-completed-configmap-recreated")
+			recreated.SetCreationTimestamp(metav1.Now())
+			handler.addObject(recreated)
+			Expect(handler.hasObjHandler(name)).To(BeTrue())
+			handler.Stop()
+		})
+
+		It("should rearm a recurring patch after it is performed", func() {
+			resourceManager := newConcurrentResourceManager("test-recurring-resource-manager")
+			resourceManager.Spec.Action = resourcemanagmentv1alpha1.ActionPatch
+			resourceManager.Spec.PatchType = resourcemanagmentv1alpha1.PatchTypeMerge
+			resourceManager.Spec.ActionParam = `{"metadata": {"labels": {"patched": "true"}}}`
+			resourceManager.Spec.Condition = resourcemanagmentv1alpha1.Expiration{ExpireAt: "00:00"}
+
+			// the occurrence recorded on the object is due
+			record, err := json.Marshal(scheduleRecord{
+				DueAt:      metav1.NewTime(time.Now().Add(-time.Minute)),
+				Expiration: resourceManager.Spec.Condition,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			configMap := newFakeConfigMap("test-recurring-configmap", "concurrent-configmap")
+			configMap.SetAnnotations(map[string]string{scheduleAnnotation(resourceManager): string(record)})
+			handler := newFakeResourceManagerHandler(resourceManager, taskScheduler, configMap.DeepCopy())
+			name := client.ObjectKeyFromObject(configMap)
+
+			handler.addObject(configMap)
+			objHandler := handler.getObjHandler(name)
+			Expect(objHandler).NotTo(BeNil())
+			Eventually(func() string {
+				return objHandler.TrackedObject().LastActionResult
+			}, time.Second*5, time.Millisecond*100).Should(Equal(resourcemanagmentv1alpha1.ActionResultSucceeded))
+
+			// the next occurrence is scheduled and persisted, and the handler keeps handling the object
+			Eventually(func() bool {
+				dueAt, ok := taskScheduler.DueAt(objHandler.taskKey("expire"))
+				return ok && dueAt.After(time.Now())
+			}, time.Second*5, time.Millisecond*100).Should(BeTrue())
+			obj, err := handler.resourceClient.Namespace(name.Namespace).Get(context.Background(), name.Name, metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(obj.GetLabels()).To(HaveKeyWithValue("patched", "true"))
+			next := &scheduleRecord{}
+			Expect(json.Unmarshal([]byte(obj.GetAnnotations()[scheduleAnnotation(resourceManager)]), next)).To(Succeed())
+			Expect(next.ExecutedAt).To(BeNil())
+			Expect(next.DueAt.After(time.Now())).To(BeTrue())
+			Expect(next.LastRunAt).NotTo(BeNil())
+			Expect(handler.hasObjHandler(name)).To(BeTrue())
+
+			// the observed object shows the performed occurrence as due, rescheduling does not perform it again
+			lastRunTime := objHandler.TrackedObject().LastRunTime
+			Expect(lastRunTime).NotTo(BeNil())
+			objHandler.Start()
+			Consistently(func() *metav1.Time {
+				return objHandler.TrackedObject().LastRunTime
+			}, time.Second, time.Millisecond*100).Should(Equal(lastRunTime))
+			dueAt, ok := taskScheduler.DueAt(objHandler.taskKey("expire"))
+			Expect(ok).To(BeTrue())
+			Expect(dueAt).To(BeTemporally("==", next.DueAt.Time))
+			handler.Stop()
+		})
+
+		It("should perform a patch once when it does not recur", func() {
+			resourceManager := newConcurrentResourceManager("test-once-resource-manager")
+			resourceManager.Spec.Action = resourcemanagmentv1alpha1.ActionPatch
+			resourceManager.Spec.PatchType = resourcemanagmentv1alpha1.PatchTypeMerge
+			resourceManager.Spec.ActionParam = `{"metadata": {"labels": {"patched": "true"}}}`
+			resourceManager.Spec.Condition = resourcemanagmentv1alpha1.Expiration{ExpireAt: "00:00"}
+			resourceManager.Spec.Recurrence = resourcemanagmentv1alpha1.RecurrenceOnce
+
+			record, err := json.Marshal(scheduleRecord{
+				DueAt:      metav1.NewTime(time.Now().Add(-time.Minute)),
+				Expiration: resourceManager.Spec.Condition,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			configMap := newFakeConfigMap("test-once-configmap", "concurrent-configmap")
+			configMap.SetAnnotations(map[string]string{scheduleAnnotation(resourceManager): string(record)})
+			handler := newFakeResourceManagerHandler(resourceManager, taskScheduler, configMap.DeepCopy())
+			name := client.ObjectKeyFromObject(configMap)
+
+			handler.addObject(configMap)
+			objHandler := handler.getObjHandler(name)
+			Expect(objHandler).NotTo(BeNil())
+			Eventually(func() *metav1.Time {
+				return objHandler.TrackedObject().LastRunTime
+			}, time.Second*5, time.Millisecond*100).ShouldNot(BeNil())
+
+			// the performed action is recorded, and nothing is scheduled after it
+			Eventually(taskScheduler.Len, time.Second*5, time.Millisecond*100).Should(BeZero())
+			obj, err := handler.resourceClient.Namespace(name.Namespace).Get(context.Background(), name.Name, metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			performed := &scheduleRecord{}
+			Expect(json.Unmarshal([]byte(obj.GetAnnotations()[scheduleAnnotation(resourceManager)]), performed)).To(Succeed())
+			Expect(performed.ExecutedAt).NotTo(BeNil())
+			Expect(handler.hasObjHandler(name)).To(BeTrue())
+			handler.Stop()
+		})
+
+		It("should not perform a patch again when the override changes after it is performed", func() {
+			resourceManager := newConcurrentResourceManager("test-performed-override-resource-manager")
+			resourceManager.Spec.Action = resourcemanagmentv1alpha1.ActionPatch
+			resourceManager.Spec.PatchType = resourcemanagmentv1alpha1.PatchTypeMerge
+			resourceManager.Spec.ActionParam = `{"metadata": {"labels": {"patched": "true"}}}`
+
+			executedAt := metav1.NewTime(time.Now().Add(-time.Minute))
+			record, err := json.Marshal(scheduleRecord{
+				DueAt:      executedAt,
+				Expiration: resourceManager.Spec.Condition,
+				Override:   "expire-after=1m",
+				ExecutedAt: &executedAt,
+				LastRunAt:  &executedAt,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			configMap := newFakeConfigMap("test-performed-override-configmap", "concurrent-configmap")
+			configMap.SetAnnotations(map[string]string{
+				expireAfterAnnotation:               "1m",
+				scheduleAnnotation(resourceManager): string(record),
+			})
+			handler := newFakeResourceManagerHandler(resourceManager, taskScheduler, configMap.DeepCopy())
+			name := client.ObjectKeyFromObject(configMap)
+
+			handler.addObject(configMap)
+			objHandler := handler.getObjHandler(name)
+			Expect(objHandler).NotTo(BeNil())
+
+			// the owner changes the override of the performed action, to an expiration that is already due
+			changed := configMap.DeepCopy()
+			changed.SetAnnotations(map[string]string{
+				expireAfterAnnotation:               "30s",
+				scheduleAnnotation(resourceManager): string(record),
+			})
+			objHandler.Update(changed)
+			Eventually(func() string {
+				return objHandler.TrackedObject().Override
+			}, time.Second*5, time.Millisecond*100).Should(Equal("expire-after=30s"))
+
+			Consistently(taskScheduler.Len, time.Second, time.Millisecond*100).Should(BeZero())
+			obj, err := handler.resourceClient.Namespace(name.Namespace).Get(context.Background(), name.Name, metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(obj.GetLabels()).NotTo(HaveKey("patched"))
+			updated := &scheduleRecord{}
+			Expect(json.Unmarshal([]byte(obj.GetAnnotations()[scheduleAnnotation(resourceManager)]), updated)).To(Succeed())
+			Expect(updated.Override).To(Equal("expire-after=30s"))
+			Expect(updated.ExecutedAt).NotTo(BeNil())
+			Expect(updated.ExecutedAt.Time).To(BeTemporally("~", executedAt.Time, time.Second))
+			Expect(updated.LastRunAt).NotTo(BeNil())
+			Expect(updated.DueAt.Time).To(BeTemporally("~", configMap.GetCreationTimestamp().Add(30*time.Second), time.Second))
+			handler.Stop()
+		})
+	})
+
+	Describe("when objects are warned about their expiration", func() {
+		It("should not warn again about the same expiration when the object is rescheduled", func() {
+			resourceManager := newConcurrentResourceManager("test-warned-resource-manager")
+			resourceManager.Spec.WarnBefore = []string{"1h", "10m"}
+			configMap := newFakeConfigMap("test-warned-configmap", "concurrent-configmap")
+			configMap.SetCreationTimestamp(metav1.NewTime(time.Now().Add(-30 * time.Minute)))
+			handler := newFakeResourceManagerHandler(resourceManager, taskScheduler, configMap.DeepCopy())
+			recorder := record.NewFakeRecorder(10)
+			handler.recorder = recorder
+			name := client.ObjectKeyFromObject(configMap)
+
+			// the object expires within the 1h lead time, it is warned about at once, on the object and on the resource manager
+			handler.addObject(configMap)
+			objHandler := handler.getObjHandler(name)
+			Expect(objHandler).NotTo(BeNil())
+			Eventually(recorder.Events).Should(Receive(ContainSubstring(reasonExpiring)))
+			Eventually(recorder.Events).Should(Receive(ContainSubstring(reasonExpiring)))
+
+			// the warning is persisted, neither a reschedule nor a restarted handler warn about it again
+			obj, err := handler.resourceClient.Namespace(name.Namespace).Get(context.Background(), name.Name, metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			warned := &scheduleRecord{}
+			Expect(json.Unmarshal([]byte(obj.GetAnnotations()[scheduleAnnotation(resourceManager)]), warned)).To(Succeed())
+			Expect(warned.WarnedLead).To(Equal("1h0m0s"))
+			objHandler.Start()
+			handler.removeObjHandelr(name)
+			handler.addObject(obj)
+			Expect(handler.hasObjHandler(name)).To(BeTrue())
+			Consistently(recorder.Events, time.Second).ShouldNot(Receive())
+			handler.Stop()
+		})
+	})
+
+	Describe("when objects override their expiration", func() {
+		It("should keep the overridden expirations within the maximum lifetime of the lease policy", func() {
+			resourceManager := newConcurrentResourceManager("test-override-lifetime-resource-manager")
+			resourceManager.Spec.Lease = &resourcemanagmentv1alpha1.LeasePolicy{MaxLifetime: "2h"}
+			expireAfter := newFakeConfigMap("test-override-lifetime-expire-after", "concurrent-configmap")
+			expireAfter.SetAnnotations(map[string]string{expireAfterAnnotation: "720h"})
+			expireAt := newFakeConfigMap("test-override-lifetime-expire-at", "concurrent-configmap")
+			expireAt.SetAnnotations(map[string]string{expireAtAnnotation: time.Now().Add(30 * 24 * time.Hour).UTC().Format(time.RFC3339)})
+			handler := newFakeResourceManagerHandler(resourceManager, taskScheduler, expireAfter.DeepCopy(), expireAt.DeepCopy())
+
+			for _, configMap := range []*unstructured.Unstructured{expireAfter, expireAt} {
+				handler.addObject(configMap)
+				objHandler := handler.getObjHandler(client.ObjectKeyFromObject(configMap))
+				Expect(objHandler).NotTo(BeNil())
+				Eventually(func() bool {
+					_, ok := taskScheduler.DueAt(objHandler.taskKey("expire"))
+					return ok
+				}, time.Second*5, time.Millisecond*100).Should(BeTrue())
+				dueAt, _ := taskScheduler.DueAt(objHandler.taskKey("expire"))
+				Expect(dueAt).To(BeTemporally("~", configMap.GetCreationTimestamp().Add(2*time.Hour), time.Second))
+			}
+			handler.Stop()
+		})
+	})
+
+	Describe("when objects are kept in a window", func() {
+		// dailyAt returns a daily schedule at the UTC time of day of now shifted by offset
+		dailyAt := func(offset time.Duration) string {
+			at := time.Now().UTC().Add(offset)
+			return fmt.Sprintf("%d %d * * *", at.Minute(), at.Hour())
+		}
+
+		newWindowResourceManager := func(name string, start time.Duration, end time.Duration) *resourcemanagmentv1alpha1.ResourceManager {
+			resourceManager := newConcurrentResourceManager(name)
+			resourceManager.Spec.Action = resourcemanagmentv1alpha1.ActionPatch
+			resourceManager.Spec.PatchType = resourcemanagmentv1alpha1.PatchTypeMerge
+			resourceManager.Spec.ActionParam = `{"metadata": {"labels": {"sleeping": "true"}}}`
+			resourceManager.Spec.Condition = resourcemanagmentv1alpha1.Expiration{
+				Window: &resourcemanagmentv1alpha1.Window{
+					Start:           dailyAt(start),
+					End:             dailyAt(end),
+					ExitActionParam: `{"metadata": {"labels": {"sleeping": "false"}}}`,
+				},
+				TimeZone: "UTC",
+			}
+			return resourceManager
+		}
+
+		getLabels := func(handler *ResourceManagerHandler, name types.NamespacedName) map[string]string {
+			obj, err := handler.resourceClient.Namespace(name.Namespace).Get(context.Background(), name.Name, metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			return obj.GetLabels()
+		}
+
+		It("should enter an open window at once and exit it when it closes", func() {
+			resourceManager := newWindowResourceManager("test-open-window-resource-manager", -time.Hour, time.Hour)
+			configMap := newFakeConfigMap("test-open-window-configmap", "concurrent-configmap")
+			handler := newFakeResourceManagerHandler(resourceManager, taskScheduler, configMap.DeepCopy())
+			name := client.ObjectKeyFromObject(configMap)
+
+			handler.addObject(configMap)
+			Eventually(func() map[string]string {
+				return getLabels(handler, name)
+			}, time.Second*5, time.Millisecond*100).Should(HaveKeyWithValue("sleeping", "true"))
+
+			// the exit patch is scheduled when the window closes
+			objHandler := handler.getObjHandler(name)
+			Expect(objHandler).NotTo(BeNil())
+			Eventually(func() bool {
+				_, ok := taskScheduler.DueAt(objHandler.taskKey("restore"))
+				return ok
+			}, time.Second*5, time.Millisecond*100).Should(BeTrue())
+			restoreAt, _ := taskScheduler.DueAt(objHandler.taskKey("restore"))
+			Expect(restoreAt).To(BeTemporally("~", time.Now().Add(time.Hour), time.Minute))
+			handler.Stop()
+		})
+
+		It("should exit a window that closed while the operator was down and enter the next one", func() {
+			resourceManager := newWindowResourceManager("test-closed-window-resource-manager", time.Hour, 2*time.Hour)
+			executedAt := metav1.NewTime(time.Now().Add(-2 * time.Hour))
+			restoreAt := metav1.NewTime(time.Now().Add(-time.Hour))
+			record, err := json.Marshal(scheduleRecord{
+				DueAt:      executedAt,
+				Expiration: resourceManager.Spec.Condition,
+				ExecutedAt: &executedAt,
+				RestoreAt:  &restoreAt,
+				LastRunAt:  &executedAt,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			configMap := newFakeConfigMap("test-closed-window-configmap", "concurrent-configmap")
+			configMap.SetAnnotations(map[string]string{scheduleAnnotation(resourceManager): string(record)})
+			handler := newFakeResourceManagerHandler(resourceManager, taskScheduler, configMap.DeepCopy())
+			name := client.ObjectKeyFromObject(configMap)
+
+			handler.addObject(configMap)
+			Eventually(func() map[string]string {
+				return getLabels(handler, name)
+			}, time.Second*5, time.Millisecond*100).Should(HaveKeyWithValue("sleeping", "false"))
+
+			// the object enters the window when it opens next
+			objHandler := handler.getObjHandler(name)
+			Expect(objHandler).NotTo(BeNil())
+			Eventually(func() bool {
+				_, ok := taskScheduler.DueAt(objHandler.taskKey("expire"))
+				return ok
+			}, time.Second*5, time.Millisecond*100).Should(BeTrue())
+			dueAt, _ := taskScheduler.DueAt(objHandler.taskKey("expire"))
+			Expect(dueAt).To(BeTemporally("~", time.Now().Add(time.Hour), time.Minute))
+			handler.Stop()
+		})
+	})
+
+	Describe("when resource managers are reconciled concurrently", func() {
+		It("should register a single handler per resource manager", func() {
+			r := &ResourceManagerReconciler{resourceManagerHandlers: make(map[types.NamespacedName]*ResourceManagerHandler)}
+			resourceManager := newConcurrentResourceManager("test-registry-resource-manager")
+			name := client.ObjectKeyFromObject(resourceManager)
+
+			var handlers []*ResourceManagerHandler
+			var funcs []func()
+			for i := 0; i < 10; i++ {
+				handler := newFakeResourceManagerHandler(resourceManager, taskScheduler)
+				handlers = append(handlers, handler)
+				funcs = append(funcs,
+					func() { r.registerAndRunResourceManagerHandler(name, handler) },
+					func() { r.findResourceManagerHandler(name) },
+					func() { r.removeResourceManagerHandler(name) },
+				)
+			}
+			concurrently(funcs...)
+
+			// the handlers that are not registered anymore are stopped
+			registered := r.findResourceManagerHandler(name)
+			for _, handler := range handlers {
+				if handler == registered {
+					continue
+				}
+				handler.objHandlersLock.RLock()
+				state := handler.state
+				handler.objHandlersLock.RUnlock()
+				Expect(state).To(Equal(handlerStopped))
+			}
+			r.removeResourceManagerHandler(name)
+			Expect(r.findResourceManagerHandler(name)).To(BeNil())
+		})
+
+		It("should not share the resource manager the reconciler decodes into with the running tasks", func() {
+			resourceManager := newConcurrentResourceManager("test-decoded-resource-manager")
+			resourceManager.Spec.Action = resourcemanagmentv1alpha1.ActionPatch
+			resourceManager.Spec.PatchType = resourcemanagmentv1alpha1.PatchTypeMerge
+			resourceManager.Spec.ActionParam = `{"metadata": {"labels": {"patched": "true"}}}`
+			resourceManager.Spec.Recurrence = resourcemanagmentv1alpha1.RecurrenceOnce
+
+			// the actions of the objects are due, they run on the workers while the resource manager is decoded
+			record, err := json.Marshal(scheduleRecord{
+				DueAt:      metav1.NewTime(time.Now().Add(-time.Minute)),
+				Expiration: resourceManager.Spec.Condition,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			var configMaps []*unstructured.Unstructured
+			var objs []k8sruntime.Object
+			for i := 0; i < 20; i++ {
+				configMap := newFakeConfigMap(fmt.Sprintf("test-decoded-configmap-%d", i), "concurrent-configmap")
+				configMap.SetAnnotations(map[string]string{scheduleAnnotation(resourceManager): string(record)})
+				configMaps = append(configMaps, configMap)
+				objs = append(objs, configMap.DeepCopy())
+			}
+			handler := newFakeResourceManagerHandler(resourceManager, taskScheduler, objs...)
+			updated := resourceManager.DeepCopy()
+			handler.Update(updated, nil)
+
+			// decode mimics the reconciler reading the API responses (ex: the finalizer and status updates) into its objects
+			decoded, err := json.Marshal(resourceManager)
+			Expect(err).NotTo(HaveOccurred())
+			decode := func(obj *resourcemanagmentv1alpha1.ResourceManager) func() {
+				return func() {
+					for i := 0; i < 100; i++ {
+						Expect(json.Unmarshal(decoded, obj)).To(Succeed())
+						time.Sleep(time.Millisecond)
+					}
+				}
+			}
+			concurrently(
+				func() {
+					for _, configMap := range configMaps {
+						handler.addObject(configMap)
+					}
+				},
+				decode(resourceManager),
+				decode(updated),
+			)
+
+			Eventually(func() bool {
+				for _, configMap := range configMaps {
+					objHandler := handler.getObjHandler(client.ObjectKeyFromObject(configMap))
+					if objHandler == nil || objHandler.TrackedObject().LastRunTime == nil {
+						return false
+					}
+				}
+				return true
+			}, time.Second*5, time.Millisecond*100).Should(BeTrue())
+			handler.Stop()
+		})
+	})
+})
@@ -21,21 +21,32 @@ import (
 	"fmt"
 	"os"
 	"runtime"
+	"sync"
 	"time"
 
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 
 	"github.com/go-logr/logr"
 	resourcemanagmentv1alpha1 "github.com/tikalk/resource-manager/api/v1alpha1"
+	"github.com/tikalk/resource-manager/controllers/notifier"
+	"github.com/tikalk/resource-manager/controllers/scheduler"
 	"go.uber.org/zap/zapcore"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	k8sruntime "k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/client/config"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	zaplogfmt "github.com/sykesm/zap-logfmt"
 	uzap "go.uber.org/zap"
@@ -50,36 +61,83 @@ import (
 //+kubebuilder:rbac:groups=resource-management.tikalk.com,resources=resourcemanagers/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=resource-management.tikalk.com,resources=resourcemanagers/finalizers,verbs=update
 
-//+kubebuilder:rbac:groups=*,resources=namespaces,verbs=get;list;watch;create;update;patch;delete
-//+kubebuilder:rbac:groups=*,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+// any kind may be managed by a ResourceManager, so the operator requires access to all resources
+//+kubebuilder:rbac:groups=*,resources=*,verbs=get;list;watch;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 // ResourceManagerReconciler reconciles a ResourceManager object
 type ResourceManagerReconciler struct {
 	client.Client
-	Scheme                  *k8sruntime.Scheme
-	resourceManagerHandlers map[types.NamespacedName]*ResourceManagerHandler
-
-	clientset *kubernetes.Clientset
-	log       logr.Logger
+	Scheme *k8sruntime.Scheme
+	// Scheduler is shared by all the handlers, it performs the actions when they are due.
+	// A scheduler is created and added to the manager when it is nil.
+	Scheduler *scheduler.Scheduler
+	// Recorder records the expiration warnings and the action results as events.
+	// The recorder of the manager is used when it is nil.
+	Recorder record.EventRecorder
+	// Notifier delivers the notifications of all the handlers.
+	// A notifier is created and added to the manager when it is nil.
+	Notifier *notifier.Notifier
+
+	// resourceManagerHandlersLock guards the registry of the handlers, which is read by concurrent reconciles
+	resourceManagerHandlersLock sync.Mutex
+	resourceManagerHandlers     map[types.NamespacedName]*ResourceManagerHandler
+
+	dynamicClient dynamic.Interface
+	restMapper    meta.RESTMapper
+	// handlerEvents triggers a reconcile when the state tracked by a handler changes, so the status is updated
+	handlerEvents chan event.GenericEvent
+	log           logr.Logger
 }
 
-// registerAndRunResourceManagerHandler add the handler to the collection and then run it
+// resourceManagerFinalizer keeps a deleted resource manager until its deletion policy is applied to its objects
+const resourceManagerFinalizer = "resource-management.tikalk.com/finalizer"
+
+// registerAndRunResourceManagerHandler add the handler to the collection and then run it, a handler it replaces is stopped
 func (r *ResourceManagerReconciler) registerAndRunResourceManagerHandler(resourceManagerName types.NamespacedName, resourceManagerHandler *ResourceManagerHandler) {
+	r.resourceManagerHandlersLock.Lock()
+	previous := r.resourceManagerHandlers[resourceManagerName]
 	r.resourceManagerHandlers[resourceManagerName] = resourceManagerHandler
-	go resourceManagerHandler.Run()
+	handlerMetrics.set(resourceManagerLabel(resourceManagerName), resourceManagerHandler)
+	r.resourceManagerHandlersLock.Unlock()
 
+	if previous != nil {
+		previous.Stop()
+	}
+	go resourceManagerHandler.Run()
 }
 
 // findResourceManagerHandler will look for the resource manager handler object in the collection
 func (r *ResourceManagerReconciler) findResourceManagerHandler(resourceManagerName types.NamespacedName) *ResourceManagerHandler {
+	r.resourceManagerHandlersLock.Lock()
+	defer r.resourceManagerHandlersLock.Unlock()
 	return r.resourceManagerHandlers[resourceManagerName]
 }
 
+// takeResourceManagerHandler removes the handler from the collection and returns it, nil when it is not registered
+func (r *ResourceManagerReconciler) takeResourceManagerHandler(resourceManagerName types.NamespacedName) *ResourceManagerHandler {
+	r.resourceManagerHandlersLock.Lock()
+	defer r.resourceManagerHandlersLock.Unlock()
+	resourceManagerHandler, ok := r.resourceManagerHandlers[resourceManagerName]
+	if !ok {
+		return nil
+	}
+	delete(r.resourceManagerHandlers, resourceManagerName)
+	handlerMetrics.set(resourceManagerLabel(resourceManagerName), nil)
+	return resourceManagerHandler
+}
+
+// releaseResourceManagerHandler removes the handler from the collection without stopping its object handlers, which are returned
+func (r *ResourceManagerReconciler) releaseResourceManagerHandler(resourceManagerName types.NamespacedName) map[types.NamespacedName]*ObjectHandler {
+	if resourceManagerHandler := r.takeResourceManagerHandler(resourceManagerName); resourceManagerHandler != nil {
+		return resourceManagerHandler.release()
+	}
+	return nil
+}
+
 func (r *ResourceManagerReconciler) removeResourceManagerHandler(resourceManagerName types.NamespacedName) {
-	if _, ok := r.resourceManagerHandlers[resourceManagerName]; ok {
-		// l.Info(fmt.Sprintf("ResourceManager %s changed. Recreating...", req.NamespacedName))
-		r.resourceManagerHandlers[resourceManagerName].Stop()
-		delete(r.resourceManagerHandlers, resourceManagerName)
+	if resourceManagerHandler := r.takeResourceManagerHandler(resourceManagerName); resourceManagerHandler != nil {
+		resourceManagerHandler.Stop()
 	}
 }
 
@@ -99,14 +157,19 @@ func (r *ResourceManagerReconciler) removeResourceManagerHandler(resourceManager
 // Reconcile is responsible for enforcing the desired CR state on the actual state of the system.
 // It runs each time an event occurs on a watched CR or resource
 func (r *ResourceManagerReconciler) Reconcile(ctx context.Context, request ctrl.Request) (ctrl.Result, error) {
+	return r.reconcile(ctx, request, &resourcemanagmentv1alpha1.ResourceManager{})
+}
+
+// reconcile handles a ResourceManager or a ClusterResourceManager, resourceManager is the empty object to fetch
+func (r *ResourceManagerReconciler) reconcile(ctx context.Context, request ctrl.Request, resourceManager resourcemanagmentv1alpha1.ResourceManagerObject) (ctrl.Result, error) {
 
 	r.log.Info(trace(fmt.Sprintf("ResourceManager object <%s> reconciled. Reconciling...", request.NamespacedName)))
 
-	resourceManager := &resourcemanagmentv1alpha1.ResourceManager{}
 	if err := r.Get(ctx, request.NamespacedName, resourceManager); err != nil {
 		if errors.IsNotFound(err) {
 			r.log.Info(fmt.Sprintf("ResourceManager object %s deleted. Removing...", request.NamespacedName))
 			r.removeResourceManagerHandler(request.NamespacedName)
+			deleteActionMetrics(resourceManagerLabel(request.NamespacedName))
 			return ctrl.Result{}, nil
 		}
 
@@ -114,37 +177,209 @@ func (r *ResourceManagerReconciler) Reconcile(ctx context.Context, request ctrl.
 		return ctrl.Result{}, nil
 	}
 
-	if resourceManagerHandler := r.findResourceManagerHandler(request.NamespacedName); resourceManagerHandler != nil {
-		//r.log.Info(trace(fmt.Sprintf("ResourceManager object updated: \nold <%+v> \nnew <%+v>.", oldObj.resourceManager, resourceManager)))
-		if reflect.DeepEqual(resourceManager.Spec, resourceManagerHandler.resourceManager.Spec) {
-			r.log.Info(trace(fmt.Sprintf("ResourceManager spec is not changed <%s>. Ignoring...", request.NamespacedName)))
-			return ctrl.Result{}, nil
-		}
-		r.log.Info(trace(fmt.Sprintf("ResourceManager object updated <%s>. Removing handler...", request.NamespacedName)))
+	if !resourceManager.GetDeletionTimestamp().IsZero() {
+		return r.finalize(ctx, request.NamespacedName, resourceManager)
+	}
+
+	// objects created before the validating webhook was enabled may be invalid, their status is written before the finalizer is added
+	if err := resourceManager.Validate(); err != nil && !resourceManager.GetSpec().Disabled {
+		r.log.Error(err, fmt.Sprintf("ResourceManager object %s is invalid.", request.NamespacedName))
 		r.removeResourceManagerHandler(request.NamespacedName)
+		return ctrl.Result{}, r.updateStatus(ctx, resourceManager, nil, err)
 	}
+	if !controllerutil.ContainsFinalizer(resourceManager, resourceManagerFinalizer) {
+		r.log.Info(trace(fmt.Sprintf("ResourceManager object <%s> has no finalizer. Adding...", request.NamespacedName)))
+		controllerutil.AddFinalizer(resourceManager, resourceManagerFinalizer)
+		if err := r.Update(ctx, resourceManager); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// the object handlers of the previous handler, when the update keeps the managed kind
+	var adopted map[types.NamespacedName]*ObjectHandler
+	var reschedule bool
+	resourceManagerHandler := r.findResourceManagerHandler(request.NamespacedName)
+	if resourceManagerHandler != nil {
+		previous, _ := resourceManagerHandler.getResourceManager()
+		if reflect.DeepEqual(resourceManager.GetSpec(), previous.GetSpec()) {
+			r.log.Info(trace(fmt.Sprintf("ResourceManager spec is not changed <%s>. Updating status...", request.NamespacedName)))
+			return ctrl.Result{}, r.updateStatus(ctx, resourceManager, resourceManagerHandler, nil)
+		}
 
-	if resourceManager.Spec.Disabled {
+		// the objects keep their schedules unless the update changes the managed kind, or disables the resource manager
+		active := !resourceManager.GetSpec().Disabled && resourceManager.Validate() == nil
+		switch {
+		case active && sameObjects(previous.GetSpec(), resourceManager.GetSpec()):
+			sender, err := newNotificationSender(resourceManager, r.dynamicClient, r.Notifier, r.log)
+			if err == nil {
+				r.log.Info(trace(fmt.Sprintf("ResourceManager object updated <%s>. Updating handler...", request.NamespacedName)))
+				resourceManagerHandler.Update(resourceManager, sender)
+				return ctrl.Result{}, r.updateStatus(ctx, resourceManager, resourceManagerHandler, nil)
+			}
+			r.log.Info(trace(fmt.Sprintf("ResourceManager object updated <%s>. Removing handler...", request.NamespacedName)))
+			r.removeResourceManagerHandler(request.NamespacedName)
+		case active && sameKind(previous.GetSpec(), resourceManager.GetSpec()):
+			r.log.Info(trace(fmt.Sprintf("ResourceManager object selector updated <%s>. Replacing handler...", request.NamespacedName)))
+			reschedule = scheduleChanged(previous.GetSpec(), resourceManager.GetSpec())
+			adopted = r.releaseResourceManagerHandler(request.NamespacedName)
+		default:
+			r.log.Info(trace(fmt.Sprintf("ResourceManager object updated <%s>. Removing handler...", request.NamespacedName)))
+			r.removeResourceManagerHandler(request.NamespacedName)
+		}
+	}
+
+	if resourceManager.GetSpec().Disabled {
 		r.log.Info(trace(fmt.Sprintf("ResourceManager object disabled <%s>. Ignoring...", request.NamespacedName)))
-		return ctrl.Result{}, nil
+		return ctrl.Result{}, r.updateStatus(ctx, resourceManager, nil, nil)
 	}
 
 	r.log.Info(trace(fmt.Sprintf("ResourceManager object added <%s>. Handler creating...", request.NamespacedName)))
-	resourceManagerHandler, err := NewResourceManagerHandler(resourceManager, r.clientset, r.log)
+	resourceManagerHandler, err := NewResourceManagerHandler(resourceManager, r.dynamicClient, r.restMapper, r.Scheduler, r.Recorder, r.Notifier, r.handlerChanged(resourceManager), r.log)
 	if err != nil {
 		r.log.Error(err, fmt.Sprintf("ResourceManagerHandler object %s handler creating failed with error <%s>.", request.NamespacedName, err))
-		return ctrl.Result{}, nil
+		for _, objHandler := range adopted {
+			objHandler.Stop()
+		}
+		return ctrl.Result{}, r.updateStatus(ctx, resourceManager, nil, err)
+	}
+
+	if adopted != nil {
+		resourceManagerHandler.adopt(adopted, reschedule)
 	}
 
 	// add handler to resourceManagerHandlers
 	r.log.Info(trace(fmt.Sprintf("ResourceManagerHandler for <%s> registering...", request.NamespacedName)))
 	r.registerAndRunResourceManagerHandler(request.NamespacedName, resourceManagerHandler)
 
-	return ctrl.Result{}, nil
+	return ctrl.Result{}, r.updateStatus(ctx, resourceManager, resourceManagerHandler, nil)
+}
+
+// finalize applies the deletion policy of a deleted resource manager to its objects, then it removes the finalizer.
+// A disabled resource manager has no objects to apply the policy to.
+func (r *ResourceManagerReconciler) finalize(ctx context.Context, resourceManagerName types.NamespacedName, resourceManager resourcemanagmentv1alpha1.ResourceManagerObject) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(resourceManager, resourceManagerFinalizer) {
+		r.removeResourceManagerHandler(resourceManagerName)
+		return ctrl.Result{}, nil
+	}
+
+	resourceManagerHandler := r.findResourceManagerHandler(resourceManagerName)
+	if resourceManagerHandler == nil && !resourceManager.GetSpec().Disabled && resourceManager.Validate() == nil {
+		// the resource manager was deleted while the operator was down, its objects are listed without scheduling their actions
+		r.log.Info(trace(fmt.Sprintf("ResourceManager object <%s> deleted. Listing its objects...", resourceManagerName)))
+		handler, err := NewResourceManagerHandler(resourceManager, r.dynamicClient, r.restMapper, r.Scheduler, r.Recorder, r.Notifier, r.handlerChanged(resourceManager), r.log)
+		if err != nil {
+			r.log.Error(err, fmt.Sprintf("ResourceManagerHandler object %s handler creating failed with error <%s>.", resourceManagerName, err))
+		} else {
+			handler.setFinalizing()
+			r.registerAndRunResourceManagerHandler(resourceManagerName, handler)
+			resourceManagerHandler = handler
+		}
+	}
+
+	if resourceManagerHandler != nil {
+		if !resourceManagerHandler.HasSynced() {
+			return ctrl.Result{RequeueAfter: time.Second}, nil
+		}
+		policy := resourceManager.GetSpec().OnDelete
+		r.log.Info(trace(fmt.Sprintf("ResourceManager object <%s> deleted. Applying the <%s> deletion policy...", resourceManagerName, policy)))
+		for _, objHandler := range r.releaseResourceManagerHandler(resourceManagerName) {
+			objHandler.finalize(policy)
+		}
+	}
+	deleteActionMetrics(resourceManagerLabel(resourceManagerName))
+
+	r.log.Info(trace(fmt.Sprintf("ResourceManager object <%s> finalized. Removing finalizer...", resourceManagerName)))
+	controllerutil.RemoveFinalizer(resourceManager, resourceManagerFinalizer)
+	return ctrl.Result{}, r.Update(ctx, resourceManager)
+}
+
+// handlerEventsBuffer is the number of reconcile requests of the handlers waiting for the controller
+const handlerEventsBuffer = 1024
+
+// handlerChanged returns a callback that requests a reconcile of the resource manager.
+// The callback runs on the scheduler workers, so it never blocks: the request carries only the name of the resource manager,
+// which the reconcile keeps mutating, and it is dropped when the buffer is full, the status is then written by a later reconcile.
+func (r *ResourceManagerReconciler) handlerChanged(resourceManager resourcemanagmentv1alpha1.ResourceManagerObject) func() {
+	obj := &metav1.PartialObjectMetadata{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      resourceManager.GetName(),
+			Namespace: resourceManager.GetNamespace(),
+		},
+	}
+	return func() {
+		select {
+		case r.handlerEvents <- event.GenericEvent{Object: obj}:
+		default:
+			r.log.Info(trace(fmt.Sprintf("ResourceManager object <%s> reconcile request dropped", client.ObjectKeyFromObject(obj))))
+		}
+	}
+}
+
+// updateStatus writes the conditions and the tracked objects of the resource manager.
+// resourceManagerHandler is nil when the resource manager is disabled or specErr prevented its creation.
+func (r *ResourceManagerReconciler) updateStatus(ctx context.Context, resourceManager resourcemanagmentv1alpha1.ResourceManagerObject, resourceManagerHandler *ResourceManagerHandler, specErr error) error {
+	status := resourceManager.GetStatus().DeepCopy()
+	status.ObservedGeneration = resourceManager.GetGeneration()
+	status.MatchedObjects = 0
+	status.TrackedObjects = nil
+
+	setCondition := func(conditionType string, conditionStatus metav1.ConditionStatus, reason string, message string) {
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               conditionType,
+			Status:             conditionStatus,
+			ObservedGeneration: resourceManager.GetGeneration(),
+			Reason:             reason,
+			Message:            message,
+		})
+	}
+
+	if specErr != nil {
+		setCondition(resourcemanagmentv1alpha1.ConditionSpecInvalid, metav1.ConditionTrue, "InvalidSpec", specErr.Error())
+	} else {
+		setCondition(resourcemanagmentv1alpha1.ConditionSpecInvalid, metav1.ConditionFalse, "ValidSpec", "")
+	}
+
+	switch {
+	case specErr != nil:
+		setCondition(resourcemanagmentv1alpha1.ConditionReady, metav1.ConditionFalse, "InvalidSpec", "the spec cannot be handled")
+	case resourceManagerHandler == nil:
+		setCondition(resourcemanagmentv1alpha1.ConditionReady, metav1.ConditionFalse, "Disabled", "the resource manager is disabled")
+	case !resourceManagerHandler.HasSynced():
+		setCondition(resourcemanagmentv1alpha1.ConditionReady, metav1.ConditionFalse, "Syncing", "listing the managed objects")
+	default:
+		setCondition(resourcemanagmentv1alpha1.ConditionReady, metav1.ConditionTrue, "Watching", "the managed objects are watched")
+	}
+
+	failed := 0
+	if resourceManagerHandler != nil {
+		status.MatchedObjects, status.TrackedObjects = resourceManagerHandler.TrackedObjects()
+		for _, tracked := range status.TrackedObjects {
+			if tracked.LastActionResult == resourcemanagmentv1alpha1.ActionResultFailed {
+				failed++
+			}
+		}
+	}
+	if failed > 0 {
+		setCondition(resourcemanagmentv1alpha1.ConditionDegraded, metav1.ConditionTrue, "ActionFailed", fmt.Sprintf("the action failed on %d objects", failed))
+	} else {
+		setCondition(resourcemanagmentv1alpha1.ConditionDegraded, metav1.ConditionFalse, "ActionsSucceeded", "")
+	}
+
+	if equality.Semantic.DeepEqual(status, resourceManager.GetStatus()) {
+		return nil
+	}
+	*resourceManager.GetStatus() = *status
+	return r.Status().Update(ctx, resourceManager)
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *ResourceManagerReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return r.setupWithManager(mgr, &resourcemanagmentv1alpha1.ResourceManager{}, r)
+}
+
+// setupWithManager sets up the controller of a ResourceManager or a ClusterResourceManager with the Manager,
+// reconciler is the outer reconciler that fetches the kind of resourceManager
+func (r *ResourceManagerReconciler) setupWithManager(mgr ctrl.Manager, resourceManager resourcemanagmentv1alpha1.ResourceManagerObject, reconciler reconcile.Reconciler) error {
 
 	configLog := uzap.NewProductionEncoderConfig()
 	configLog.EncodeTime = func(ts time.Time, encoder zapcore.PrimitiveArrayEncoder) {
@@ -155,21 +390,36 @@ func (r *ResourceManagerReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	r.log = zap.New(zap.UseDevMode(true), zap.WriteTo(os.Stdout), zap.Encoder(logfmtEncoder))
 	log.SetLogger(r.log)
 
-	// resourceManagerHandlers = make(map[types.NamespacedName]chan struct{})
-	cfg, err := config.GetConfig()
-	if err != nil {
-		panic(err.Error())
-	}
-
 	r.resourceManagerHandlers = make(map[types.NamespacedName]*ResourceManagerHandler)
 
-	r.clientset, err = kubernetes.NewForConfig(cfg)
+	var err error
+	r.dynamicClient, err = dynamic.NewForConfig(mgr.GetConfig())
 	if err != nil {
-		panic(err.Error())
+		return err
 	}
+	r.restMapper = mgr.GetRESTMapper()
+	r.handlerEvents = make(chan event.GenericEvent, handlerEventsBuffer)
+
+	if r.Recorder == nil {
+		r.Recorder = mgr.GetEventRecorderFor("resource-manager")
+	}
+	if r.Notifier == nil {
+		r.Notifier = notifier.New(notifier.DefaultWorkers, r.log.WithName("notifier"))
+		if err := mgr.Add(r.Notifier); err != nil {
+			return err
+		}
+	}
+	if r.Scheduler == nil {
+		r.Scheduler = scheduler.New(scheduler.DefaultWorkers)
+		if err := mgr.Add(r.Scheduler); err != nil {
+			return err
+		}
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&resourcemanagmentv1alpha1.ResourceManager{}).
-		Complete(r)
+		For(resourceManager).
+		Watches(&source.Channel{Source: r.handlerEvents}, &handler.EnqueueRequestForObject{}).
+		Complete(reconciler)
 }
 
 // trace function adds a tracing level to the logs
@@ -2,27 +2,121 @@ package controllers
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	resourcemanagmentv1alpha1 "github.com/tikalk/resource-manager/api/v1alpha1"
 )
 
+// resourceManagerOption customizes the spec of a resource manager built by newConfigMapResourceManager
+type resourceManagerOption func(spec *resourcemanagmentv1alpha1.ResourceManagerSpec)
+
+// newConfigMapResourceManager returns a resource manager deleting the ConfigMaps of the default namespace matched by the selector
+func newConfigMapResourceManager(name string, selector *metav1.LabelSelector, condition resourcemanagmentv1alpha1.Expiration, options ...resourceManagerOption) *resourcemanagmentv1alpha1.ResourceManager {
+	resourceManager := &resourcemanagmentv1alpha1.ResourceManager{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+		},
+		Spec: resourcemanagmentv1alpha1.ResourceManagerSpec{
+			ResourceAPIVersion: "v1",
+			ResourceKind:       "ConfigMap",
+			Selector:           selector,
+			Action:             resourcemanagmentv1alpha1.ActionDelete,
+			Condition:          condition,
+		},
+	}
+	for _, option := range options {
+		option(&resourceManager.Spec)
+	}
+	return resourceManager
+}
+
+// matchName selects the objects with the name label
+func matchName(name string) *metav1.LabelSelector {
+	return &metav1.LabelSelector{MatchLabels: map[string]string{"name": name}}
+}
+
+// matchNames selects the objects with one of the name labels
+func matchNames(names ...string) *metav1.LabelSelector {
+	return &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{
+		Key:      "name",
+		Operator: metav1.LabelSelectorOpIn,
+		Values:   names,
+	}}}
+}
+
+// expireAfter expires the objects after the duration
+func expireAfter(after string) resourcemanagmentv1alpha1.Expiration {
+	return resourcemanagmentv1alpha1.Expiration{ExpireAfter: after}
+}
+
+// withPatch sets the patch action
+func withPatch(patchType string, actionParam string) resourceManagerOption {
+	return func(spec *resourcemanagmentv1alpha1.ResourceManagerSpec) {
+		spec.Action = resourcemanagmentv1alpha1.ActionPatch
+		spec.PatchType = patchType
+		spec.ActionParam = actionParam
+	}
+}
+
+// withOnDelete sets the deletion policy
+func withOnDelete(onDelete string) resourceManagerOption {
+	return func(spec *resourcemanagmentv1alpha1.ResourceManagerSpec) {
+		spec.OnDelete = onDelete
+	}
+}
+
+// withRetry sets the retry policy
+func withRetry(maxAttempts int32, backoff string) resourceManagerOption {
+	return func(spec *resourcemanagmentv1alpha1.ResourceManagerSpec) {
+		spec.Retry = &resourcemanagmentv1alpha1.RetryPolicy{MaxAttempts: &maxAttempts, Backoff: backoff}
+	}
+}
+
+// withLease sets the lease policy
+func withLease(lease resourcemanagmentv1alpha1.LeasePolicy) resourceManagerOption {
+	return func(spec *resourcemanagmentv1alpha1.ResourceManagerSpec) {
+		spec.Lease = &lease
+	}
+}
+
+// trackedObject returns a function reading the first tracked object of the resource manager status, empty when none is tracked
+func trackedObject(ctx context.Context, resourceManager *resourcemanagmentv1alpha1.ResourceManager) func() resourcemanagmentv1alpha1.TrackedObject {
+	return func() resourcemanagmentv1alpha1.TrackedObject {
+		rmObj := &resourcemanagmentv1alpha1.ResourceManager{}
+		if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(resourceManager), rmObj); err != nil || len(rmObj.Status.TrackedObjects) == 0 {
+			return resourcemanagmentv1alpha1.TrackedObject{}
+		}
+		return rmObj.Status.TrackedObjects[0]
+	}
+}
+
 var _ = Context("Inside of a ResourceManager", func() {
 	ctx := context.TODO()
 	SetupTest(ctx)
 
 	Describe("when no existing resources exist", func() {
 		It("when creating a new resource manager object and a namespace obj and wait one minute", func() {
-			myResourceManagerObj := &resourcemanagmentv1alpha1.ResourceManager{
+			myResourceManagerObj := &resourcemanagmentv1alpha1.ClusterResourceManager{
 				ObjectMeta: metav1.ObjectMeta{
-					Name:      "test-resource-manager",
-					Namespace: "default",
+					Name: "test-resource-manager",
 				},
 				Spec: resourcemanagmentv1alpha1.ResourceManagerSpec{
 					Disabled:     false,
@@ -41,13 +135,12 @@ var _ = Context("Inside of a ResourceManager", func() {
 			}
 
 			err := k8sClient.Create(ctx, myResourceManagerObj)
-			Expect(err).NotTo(HaveOccurred(), "failed to create test 'ResourceManager' resource")
+			Expect(err).NotTo(HaveOccurred(), "failed to create test 'ClusterResourceManager' resource")
 
-			rmObj := &resourcemanagmentv1alpha1.ResourceManager{}
+			rmObj := &resourcemanagmentv1alpha1.ClusterResourceManager{}
 			Eventually(
 
-				getResourceFunc(ctx, client.ObjectKey{Name: "test-resource-manager",
-					Namespace: myResourceManagerObj.Namespace}, rmObj),
+				getResourceFunc(ctx, client.ObjectKey{Name: "test-resource-manager"}, rmObj),
 
 				time.Second*5, time.Millisecond*500).Should(BeNil())
 
@@ -78,6 +171,1113 @@ var _ = Context("Inside of a ResourceManager", func() {
 			nsObj, _ := getResourceByName(ctx, "test-namespace")
 			Expect(string(nsObj.Status.Phase)).To(Not(Equal("Active")))
 		})
+
+		It("should not let a ResourceManager manage a cluster-scoped kind", func() {
+			myResourceManagerObj := &resourcemanagmentv1alpha1.ResourceManager{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-cluster-scoped-resource-manager",
+					Namespace: "default",
+				},
+				Spec: resourcemanagmentv1alpha1.ResourceManagerSpec{
+					// not a well known cluster-scoped kind, so it is rejected by its REST mapping
+					ResourceAPIVersion: "scheduling.k8s.io/v1",
+					ResourceKind:       "PriorityClass",
+					Selector:           &metav1.LabelSelector{},
+					Action:             "delete",
+					Condition: resourcemanagmentv1alpha1.Expiration{
+						ExpireAfter: "1s",
+					},
+				},
+			}
+			err := k8sClient.Create(ctx, myResourceManagerObj)
+			Expect(err).NotTo(HaveOccurred(), "failed to create test 'ResourceManager' resource")
+
+			rmObj := &resourcemanagmentv1alpha1.ResourceManager{}
+			Eventually(func() bool {
+				if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(myResourceManagerObj), rmObj); err != nil {
+					return false
+				}
+				return meta.IsStatusConditionTrue(rmObj.Status.Conditions, resourcemanagmentv1alpha1.ConditionSpecInvalid)
+			}, time.Second*10, time.Millisecond*500).Should(BeTrue())
+			Expect(meta.FindStatusCondition(rmObj.Status.Conditions, resourcemanagmentv1alpha1.ConditionSpecInvalid).Message).To(ContainSubstring("ClusterResourceManager"))
+		})
+	})
+
+	Describe("when managing a kind by its apiVersion", func() {
+		It("should delete a labeled ConfigMap after it expired", func() {
+			myResourceManagerObj := &resourcemanagmentv1alpha1.ResourceManager{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-configmap-resource-manager",
+					Namespace: "default",
+				},
+				Spec: resourcemanagmentv1alpha1.ResourceManagerSpec{
+					ResourceAPIVersion: "v1",
+					ResourceKind:       "ConfigMap",
+					Selector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{
+							"name": "managed-configmap",
+						},
+					},
+					Action: "delete",
+					Condition: resourcemanagmentv1alpha1.Expiration{
+						ExpireAfter: "1s",
+					},
+				},
+			}
+			err := k8sClient.Create(ctx, myResourceManagerObj)
+			Expect(err).NotTo(HaveOccurred(), "failed to create test 'ResourceManager' resource")
+
+			myConfigMapObj := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-configmap",
+				Namespace: "default",
+				Labels: map[string]string{
+					"name": "managed-configmap",
+				},
+			}}
+			err = k8sClient.Create(ctx, myConfigMapObj)
+			Expect(err).NotTo(HaveOccurred(), "failed to create test 'configmap' resource")
+
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, client.ObjectKeyFromObject(myConfigMapObj), &v1.ConfigMap{})
+				return errors.IsNotFound(err)
+			}, time.Second*10, time.Millisecond*500).Should(BeTrue())
+		})
+	})
+
+	Describe("when objects are matched", func() {
+		It("should report the tracked objects in the status", func() {
+			myResourceManagerObj := &resourcemanagmentv1alpha1.ResourceManager{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-status-resource-manager",
+					Namespace: "default",
+				},
+				Spec: resourcemanagmentv1alpha1.ResourceManagerSpec{
+					ResourceKind: "ConfigMap",
+					Selector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{
+							"name": "tracked-configmap",
+						},
+					},
+					Action: "delete",
+					Condition: resourcemanagmentv1alpha1.Expiration{
+						ExpireAfter: "1h",
+					},
+				},
+			}
+			err := k8sClient.Create(ctx, myResourceManagerObj)
+			Expect(err).NotTo(HaveOccurred(), "failed to create test 'ResourceManager' resource")
+
+			myConfigMapObj := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-tracked-configmap",
+				Namespace: "default",
+				Labels: map[string]string{
+					"name": "tracked-configmap",
+				},
+			}}
+			err = k8sClient.Create(ctx, myConfigMapObj)
+			Expect(err).NotTo(HaveOccurred(), "failed to create test 'configmap' resource")
+
+			rmObj := &resourcemanagmentv1alpha1.ResourceManager{}
+			Eventually(func() int {
+				if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(myResourceManagerObj), rmObj); err != nil {
+					return -1
+				}
+				return rmObj.Status.MatchedObjects
+			}, time.Second*10, time.Millisecond*500).Should(Equal(1))
+
+			Expect(meta.IsStatusConditionTrue(rmObj.Status.Conditions, resourcemanagmentv1alpha1.ConditionReady)).To(BeTrue())
+			Expect(rmObj.Status.TrackedObjects).To(HaveLen(1))
+			Expect(rmObj.Status.TrackedObjects[0].Name).To(Equal("test-tracked-configmap"))
+			Expect(rmObj.Status.TrackedObjects[0].ExpiresAt).NotTo(BeNil())
+		})
+	})
+
+	Describe("when the expiration is persisted on the objects", func() {
+		var myResourceManagerObj *resourcemanagmentv1alpha1.ResourceManager
+
+		BeforeEach(func() {
+			myResourceManagerObj = &resourcemanagmentv1alpha1.ResourceManager{
+				ObjectMeta: metav1.ObjectMeta{
+					GenerateName: "test-persisted-resource-manager-",
+					Namespace:    "default",
+				},
+				Spec: resourcemanagmentv1alpha1.ResourceManagerSpec{
+					ResourceKind: "ConfigMap",
+					Selector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{
+							"name": "persisted-configmap",
+						},
+					},
+					Action: "delete",
+					Condition: resourcemanagmentv1alpha1.Expiration{
+						ExpireAfter: "1h",
+					},
+				},
+			}
+			err := k8sClient.Create(ctx, myResourceManagerObj)
+			Expect(err).NotTo(HaveOccurred(), "failed to create test 'ResourceManager' resource")
+		})
+
+		AfterEach(func() {
+			Expect(k8sClient.Delete(ctx, myResourceManagerObj)).To(Succeed())
+		})
+
+		It("should record the expiration time on the object", func() {
+			myConfigMapObj := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-persisted-configmap",
+				Namespace: "default",
+				Labels: map[string]string{
+					"name": "persisted-configmap",
+				},
+			}}
+			err := k8sClient.Create(ctx, myConfigMapObj)
+			Expect(err).NotTo(HaveOccurred(), "failed to create test 'configmap' resource")
+
+			Eventually(func() string {
+				cm := &v1.ConfigMap{}
+				if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(myConfigMapObj), cm); err != nil {
+					return ""
+				}
+				return cm.Annotations[scheduleAnnotation(myResourceManagerObj)]
+			}, time.Second*10, time.Millisecond*500).Should(ContainSubstring(`"dueAt"`))
+		})
+
+		It("should honor a recorded expiration that passed while the operator was down", func() {
+			record := fmt.Sprintf(`{"dueAt":"%s","expiration":{"after":"1h"}}`, time.Now().Add(-time.Minute).UTC().Format(time.RFC3339))
+			myConfigMapObj := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-missed-configmap",
+				Namespace: "default",
+				Labels: map[string]string{
+					"name": "persisted-configmap",
+				},
+				Annotations: map[string]string{
+					scheduleAnnotation(myResourceManagerObj): record,
+				},
+			}}
+			err := k8sClient.Create(ctx, myConfigMapObj)
+			Expect(err).NotTo(HaveOccurred(), "failed to create test 'configmap' resource")
+
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, client.ObjectKeyFromObject(myConfigMapObj), &v1.ConfigMap{})
+				return errors.IsNotFound(err)
+			}, time.Second*10, time.Millisecond*500).Should(BeTrue())
+		})
+	})
+
+	Describe("when patching objects", func() {
+		It("should apply a JSON patch to a labeled ConfigMap", func() {
+			myResourceManagerObj := &resourcemanagmentv1alpha1.ResourceManager{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-json-patch-resource-manager",
+					Namespace: "default",
+				},
+				Spec: resourcemanagmentv1alpha1.ResourceManagerSpec{
+					ResourceAPIVersion: "v1",
+					ResourceKind:       "ConfigMap",
+					Selector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{
+							"name": "patched-configmap",
+						},
+					},
+					Action:      resourcemanagmentv1alpha1.ActionPatch,
+					PatchType:   resourcemanagmentv1alpha1.PatchTypeJSON,
+					ActionParam: `[{"op":"add","path":"/data","value":{"expired":"true"}}]`,
+					Condition: resourcemanagmentv1alpha1.Expiration{
+						ExpireAfter: "1s",
+					},
+				},
+			}
+			err := k8sClient.Create(ctx, myResourceManagerObj)
+			Expect(err).NotTo(HaveOccurred(), "failed to create test 'ResourceManager' resource")
+
+			myConfigMapObj := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-patched-configmap",
+				Namespace: "default",
+				Labels: map[string]string{
+					"name": "patched-configmap",
+				},
+			}}
+			err = k8sClient.Create(ctx, myConfigMapObj)
+			Expect(err).NotTo(HaveOccurred(), "failed to create test 'configmap' resource")
+
+			Eventually(func() (map[string]string, error) {
+				configMap := &v1.ConfigMap{}
+				err := k8sClient.Get(ctx, client.ObjectKeyFromObject(myConfigMapObj), configMap)
+				return configMap.Data, err
+			}, time.Second*10, time.Millisecond*500).Should(HaveKeyWithValue("expired", "true"))
+		})
+
+		It("should render a templated patch against the object", func() {
+			myResourceManagerObj := &resourcemanagmentv1alpha1.ResourceManager{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-template-resource-manager",
+					Namespace: "default",
+				},
+				Spec: resourcemanagmentv1alpha1.ResourceManagerSpec{
+					ResourceAPIVersion: "v1",
+					ResourceKind:       "ConfigMap",
+					Selector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{
+							"name": "templated-configmap",
+						},
+					},
+					Action:      resourcemanagmentv1alpha1.ActionPatch,
+					PatchType:   resourcemanagmentv1alpha1.PatchTypeMerge,
+					ActionParam: `{"metadata":{"labels":{"expired-by":"{{ .ResourceManager }}"}},"data":{"object":"{{ .Namespace }}/{{ .Name }}","team":"{{ index .Labels "team" }}"}}`,
+					Condition: resourcemanagmentv1alpha1.Expiration{
+						ExpireAfter: "1s",
+					},
+				},
+			}
+			err := k8sClient.Create(ctx, myResourceManagerObj)
+			Expect(err).NotTo(HaveOccurred(), "failed to create test 'ResourceManager' resource")
+
+			myConfigMapObj := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-templated-configmap",
+				Namespace: "default",
+				Labels: map[string]string{
+					"name": "templated-configmap",
+					"team": "platform",
+				},
+			}}
+			err = k8sClient.Create(ctx, myConfigMapObj)
+			Expect(err).NotTo(HaveOccurred(), "failed to create test 'configmap' resource")
+
+			configMap := &v1.ConfigMap{}
+			Eventually(func() (map[string]string, error) {
+				err := k8sClient.Get(ctx, client.ObjectKeyFromObject(myConfigMapObj), configMap)
+				return configMap.Labels, err
+			}, time.Second*10, time.Millisecond*500).Should(HaveKeyWithValue("expired-by", "test-template-resource-manager"))
+			Expect(configMap.Data).To(Equal(map[string]string{"object": "default/test-templated-configmap", "team": "platform"}))
+		})
+
+		It("should fail a templated patch that references a missing label", func() {
+			myResourceManagerObj := &resourcemanagmentv1alpha1.ResourceManager{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-missing-key-resource-manager",
+					Namespace: "default",
+				},
+				Spec: resourcemanagmentv1alpha1.ResourceManagerSpec{
+					ResourceAPIVersion: "v1",
+					ResourceKind:       "ConfigMap",
+					Selector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{
+							"name": "missing-key-configmap",
+						},
+					},
+					Action:      resourcemanagmentv1alpha1.ActionPatch,
+					PatchType:   resourcemanagmentv1alpha1.PatchTypeMerge,
+					ActionParam: `{"data":{"team":"{{ .Labels.team }}"}}`,
+					Condition: resourcemanagmentv1alpha1.Expiration{
+						ExpireAfter: "1s",
+					},
+				},
+			}
+			err := k8sClient.Create(ctx, myResourceManagerObj)
+			Expect(err).NotTo(HaveOccurred(), "failed to create test 'ResourceManager' resource")
+
+			myConfigMapObj := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-missing-key-configmap",
+				Namespace: "default",
+				Labels: map[string]string{
+					"name": "missing-key-configmap",
+				},
+			}}
+			err = k8sClient.Create(ctx, myConfigMapObj)
+			Expect(err).NotTo(HaveOccurred(), "failed to create test 'configmap' resource")
+
+			rmObj := &resourcemanagmentv1alpha1.ResourceManager{}
+			Eventually(func() string {
+				if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(myResourceManagerObj), rmObj); err != nil || len(rmObj.Status.TrackedObjects) == 0 {
+					return ""
+				}
+				return rmObj.Status.TrackedObjects[0].LastActionResult
+			}, time.Second*10, time.Millisecond*500).Should(Equal(resourcemanagmentv1alpha1.ActionResultFailed))
+			Expect(rmObj.Status.TrackedObjects[0].Message).To(ContainSubstring("team"))
+
+			configMap := &v1.ConfigMap{}
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(myConfigMapObj), configMap)).To(Succeed())
+			Expect(configMap.Data).To(BeEmpty())
+		})
+	})
+
+	Describe("when a ClusterResourceManager selects namespaces", func() {
+		It("should manage objects in the namespaces that match the namespace selector", func() {
+			selectedNs := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+				Name:   "test-selected-namespace",
+				Labels: map[string]string{"team": "a"},
+			}}
+			Expect(k8sClient.Create(ctx, selectedNs)).To(Succeed())
+			otherNs := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+				Name: "test-other-namespace",
+			}}
+			Expect(k8sClient.Create(ctx, otherNs)).To(Succeed())
+
+			myResourceManagerObj := &resourcemanagmentv1alpha1.ClusterResourceManager{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test-namespace-selector-resource-manager",
+				},
+				Spec: resourcemanagmentv1alpha1.ResourceManagerSpec{
+					ResourceAPIVersion: "v1",
+					ResourceKind:       "ConfigMap",
+					NamespaceSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"team": "a"},
+					},
+					Selector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{
+							"name": "selected-configmap",
+						},
+					},
+					Action: "delete",
+					Condition: resourcemanagmentv1alpha1.Expiration{
+						ExpireAfter: "1s",
+					},
+				},
+			}
+			err := k8sClient.Create(ctx, myResourceManagerObj)
+			Expect(err).NotTo(HaveOccurred(), "failed to create test 'ResourceManager' resource")
+
+			selectedConfigMap := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-selected-configmap",
+				Namespace: selectedNs.Name,
+				Labels:    map[string]string{"name": "selected-configmap"},
+			}}
+			Expect(k8sClient.Create(ctx, selectedConfigMap)).To(Succeed())
+			otherConfigMap := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-selected-configmap",
+				Namespace: otherNs.Name,
+				Labels:    map[string]string{"name": "selected-configmap"},
+			}}
+			Expect(k8sClient.Create(ctx, otherConfigMap)).To(Succeed())
+
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, client.ObjectKeyFromObject(selectedConfigMap), &v1.ConfigMap{})
+				return errors.IsNotFound(err)
+			}, time.Second*10, time.Millisecond*500).Should(BeTrue())
+			Consistently(func() error {
+				return k8sClient.Get(ctx, client.ObjectKeyFromObject(otherConfigMap), &v1.ConfigMap{})
+			}, time.Second*2, time.Millisecond*500).Should(Succeed())
+
+			// the objects are managed once their namespace matches the selector
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(otherNs), otherNs)).To(Succeed())
+			otherNs.Labels = map[string]string{"team": "a"}
+			Expect(k8sClient.Update(ctx, otherNs)).To(Succeed())
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, client.ObjectKeyFromObject(otherConfigMap), &v1.ConfigMap{})
+				return errors.IsNotFound(err)
+			}, time.Second*10, time.Millisecond*500).Should(BeTrue())
+		})
+	})
+
+	Describe("when managed objects are updated", func() {
+		It("should cancel the action of an object that stopped matching the selector", func() {
+			myResourceManagerObj := newConfigMapResourceManager("test-unlabeled-resource-manager", matchName("unlabeled-configmap"), expireAfter("3s"))
+			Expect(k8sClient.Create(ctx, myResourceManagerObj)).To(Succeed())
+
+			myConfigMapObj := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-unlabeled-configmap",
+				Namespace: "default",
+				Labels:    map[string]string{"name": "unlabeled-configmap"},
+			}}
+			Expect(k8sClient.Create(ctx, myConfigMapObj)).To(Succeed())
+
+			rmObj := &resourcemanagmentv1alpha1.ResourceManager{}
+			Eventually(func() int {
+				if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(myResourceManagerObj), rmObj); err != nil {
+					return -1
+				}
+				return rmObj.Status.MatchedObjects
+			}, time.Second*10, time.Millisecond*250).Should(Equal(1))
+
+			myConfigMapObj.Labels = map[string]string{"name": "relabeled-configmap"}
+			Expect(k8sClient.Update(ctx, myConfigMapObj)).To(Succeed())
+
+			Consistently(func() error {
+				return k8sClient.Get(ctx, client.ObjectKeyFromObject(myConfigMapObj), &v1.ConfigMap{})
+			}, time.Second*5, time.Millisecond*500).Should(Succeed())
+		})
+
+		It("should schedule the action of an object that started matching the selector", func() {
+			myResourceManagerObj := newConfigMapResourceManager("test-relabeled-resource-manager", matchName("relabeled-configmap"), expireAfter("1s"))
+			Expect(k8sClient.Create(ctx, myResourceManagerObj)).To(Succeed())
+
+			myConfigMapObj := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-relabeled-configmap",
+				Namespace: "default",
+				Labels:    map[string]string{"name": "unmanaged-configmap"},
+			}}
+			Expect(k8sClient.Create(ctx, myConfigMapObj)).To(Succeed())
+
+			Consistently(func() error {
+				return k8sClient.Get(ctx, client.ObjectKeyFromObject(myConfigMapObj), &v1.ConfigMap{})
+			}, time.Second*2, time.Millisecond*500).Should(Succeed())
+
+			myConfigMapObj.Labels = map[string]string{"name": "relabeled-configmap"}
+			Expect(k8sClient.Update(ctx, myConfigMapObj)).To(Succeed())
+
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, client.ObjectKeyFromObject(myConfigMapObj), &v1.ConfigMap{})
+				return errors.IsNotFound(err)
+			}, time.Second*10, time.Millisecond*500).Should(BeTrue())
+		})
+
+		It("should stop tracking an object that is being deleted", func() {
+			myResourceManagerObj := newConfigMapResourceManager("test-deleting-resource-manager", matchName("deleting-configmap"), expireAfter("1h"))
+			Expect(k8sClient.Create(ctx, myResourceManagerObj)).To(Succeed())
+
+			myConfigMapObj := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+				Name:       "test-deleting-configmap",
+				Namespace:  "default",
+				Labels:     map[string]string{"name": "deleting-configmap"},
+				Finalizers: []string{"resource-management.tikalk.com/test"},
+			}}
+			Expect(k8sClient.Create(ctx, myConfigMapObj)).To(Succeed())
+
+			rmObj := &resourcemanagmentv1alpha1.ResourceManager{}
+			matchedObjects := func() int {
+				if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(myResourceManagerObj), rmObj); err != nil {
+					return -1
+				}
+				return rmObj.Status.MatchedObjects
+			}
+			Eventually(matchedObjects, time.Second*10, time.Millisecond*250).Should(Equal(1))
+
+			// the finalizer keeps the object with a deletion timestamp
+			Expect(k8sClient.Delete(ctx, myConfigMapObj)).To(Succeed())
+			Eventually(matchedObjects, time.Second*10, time.Millisecond*250).Should(Equal(0))
+
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(myConfigMapObj), myConfigMapObj)).To(Succeed())
+			myConfigMapObj.Finalizers = nil
+			Expect(k8sClient.Update(ctx, myConfigMapObj)).To(Succeed())
+		})
+
+		It("should ignore the updates of its own annotations", func() {
+			myResourceManagerObj := newConfigMapResourceManager("test-annotated-resource-manager", matchName("annotated-configmap"), expireAfter("1h"))
+			Expect(k8sClient.Create(ctx, myResourceManagerObj)).To(Succeed())
+
+			myConfigMapObj := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-annotated-configmap",
+				Namespace: "default",
+				Labels:    map[string]string{"name": "annotated-configmap"},
+			}}
+			Expect(k8sClient.Create(ctx, myConfigMapObj)).To(Succeed())
+
+			// the schedule annotation is written once, the update it causes does not reschedule the object
+			Eventually(func() (map[string]string, error) {
+				err := k8sClient.Get(ctx, client.ObjectKeyFromObject(myConfigMapObj), myConfigMapObj)
+				return myConfigMapObj.Annotations, err
+			}, time.Second*10, time.Millisecond*250).Should(HaveLen(1))
+			resourceVersion := myConfigMapObj.ResourceVersion
+
+			Consistently(func() (string, error) {
+				err := k8sClient.Get(ctx, client.ObjectKeyFromObject(myConfigMapObj), myConfigMapObj)
+				return myConfigMapObj.ResourceVersion, err
+			}, time.Second*2, time.Millisecond*500).Should(Equal(resourceVersion))
+		})
+	})
+
+	Describe("when the resource manager is updated", func() {
+		// trackedExpirations returns the expiration of the tracked objects by name, once the status observed the last update
+		trackedExpirations := func(rmObj *resourcemanagmentv1alpha1.ResourceManager) func() map[string]time.Time {
+			return func() map[string]time.Time {
+				if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(rmObj), rmObj); err != nil {
+					return nil
+				}
+				if rmObj.Status.ObservedGeneration != rmObj.Generation {
+					return nil
+				}
+				expirations := make(map[string]time.Time)
+				for _, tracked := range rmObj.Status.TrackedObjects {
+					if tracked.ExpiresAt != nil {
+						expirations[tracked.Name] = tracked.ExpiresAt.Time
+					}
+				}
+				return expirations
+			}
+		}
+
+		createConfigMap := func(name string, label string) *v1.ConfigMap {
+			myConfigMapObj := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "default",
+				Labels:    map[string]string{"name": label},
+			}}
+			Expect(k8sClient.Create(ctx, myConfigMapObj)).To(Succeed())
+			return myConfigMapObj
+		}
+
+		It("should keep the schedules of the objects when the action changes", func() {
+			myResourceManagerObj := newConfigMapResourceManager("test-updated-action-resource-manager", matchNames("updated-action-configmap"), expireAfter("1h"))
+			Expect(k8sClient.Create(ctx, myResourceManagerObj)).To(Succeed())
+			createConfigMap("test-updated-action-configmap", "updated-action-configmap")
+
+			rmObj := &resourcemanagmentv1alpha1.ResourceManager{ObjectMeta: myResourceManagerObj.ObjectMeta}
+			Eventually(trackedExpirations(rmObj), time.Second*10, time.Millisecond*250).Should(HaveKey("test-updated-action-configmap"))
+			expiresAt := trackedExpirations(rmObj)()["test-updated-action-configmap"]
+
+			rmObj.Spec.DryRun = true
+			Expect(k8sClient.Update(ctx, rmObj)).To(Succeed())
+
+			Consistently(func() time.Time {
+				return trackedExpirations(rmObj)()["test-updated-action-configmap"]
+			}, time.Second*3, time.Millisecond*500).Should(Or(Equal(expiresAt), BeZero()))
+			Expect(trackedExpirations(rmObj)()).To(HaveKeyWithValue("test-updated-action-configmap", expiresAt))
+		})
+
+		It("should keep the schedules of the objects that still match an updated selector", func() {
+			myResourceManagerObj := newConfigMapResourceManager("test-updated-selector-resource-manager", matchNames("updated-selector-a", "updated-selector-b"), expireAfter("1h"))
+			Expect(k8sClient.Create(ctx, myResourceManagerObj)).To(Succeed())
+			createConfigMap("test-updated-selector-a", "updated-selector-a")
+			createConfigMap("test-updated-selector-b", "updated-selector-b")
+			createConfigMap("test-updated-selector-c", "updated-selector-c")
+
+			rmObj := &resourcemanagmentv1alpha1.ResourceManager{ObjectMeta: myResourceManagerObj.ObjectMeta}
+			Eventually(trackedExpirations(rmObj), time.Second*10, time.Millisecond*250).Should(HaveLen(2))
+			expiresAt := trackedExpirations(rmObj)()["test-updated-selector-b"]
+			Expect(expiresAt).NotTo(BeZero())
+
+			time.Sleep(time.Second)
+			rmObj.Spec.Selector.MatchExpressions[0].Values = []string{"updated-selector-b", "updated-selector-c"}
+			Expect(k8sClient.Update(ctx, rmObj)).To(Succeed())
+
+			Eventually(func() []string {
+				var names []string
+				for name := range trackedExpirations(rmObj)() {
+					names = append(names, name)
+				}
+				return names
+			}, time.Second*10, time.Millisecond*250).Should(ConsistOf("test-updated-selector-b", "test-updated-selector-c"))
+			expirations := trackedExpirations(rmObj)()
+			Expect(expirations).To(HaveKeyWithValue("test-updated-selector-b", expiresAt))
+			Expect(expirations["test-updated-selector-c"]).To(BeTemporally(">", expiresAt))
+		})
+
+		It("should reschedule the objects when the expiration changes", func() {
+			myResourceManagerObj := newConfigMapResourceManager("test-updated-expiration-resource-manager", matchNames("updated-expiration-configmap"), expireAfter("1h"))
+			Expect(k8sClient.Create(ctx, myResourceManagerObj)).To(Succeed())
+			myConfigMapObj := createConfigMap("test-updated-expiration-configmap", "updated-expiration-configmap")
+
+			rmObj := &resourcemanagmentv1alpha1.ResourceManager{ObjectMeta: myResourceManagerObj.ObjectMeta}
+			Eventually(trackedExpirations(rmObj), time.Second*10, time.Millisecond*250).Should(HaveLen(1))
+
+			rmObj.Spec.Condition.ExpireAfter = "1s"
+			Expect(k8sClient.Update(ctx, rmObj)).To(Succeed())
+
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, client.ObjectKeyFromObject(myConfigMapObj), &v1.ConfigMap{})
+				return errors.IsNotFound(err)
+			}, time.Second*10, time.Millisecond*500).Should(BeTrue())
+		})
+	})
+
+	Describe("when the resource manager is deleted", func() {
+		// deleteResourceManager deletes the resource manager once its objects are tracked, and waits for its finalizer
+		deleteResourceManager := func(rmObj *resourcemanagmentv1alpha1.ResourceManager) {
+			Eventually(func() int {
+				if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(rmObj), rmObj); err != nil {
+					return -1
+				}
+				return rmObj.Status.MatchedObjects
+			}, time.Second*10, time.Millisecond*250).Should(Equal(1))
+			Expect(rmObj.Finalizers).To(ContainElement(resourceManagerFinalizer))
+
+			Expect(k8sClient.Delete(ctx, rmObj)).To(Succeed())
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, client.ObjectKeyFromObject(rmObj), &resourcemanagmentv1alpha1.ResourceManager{})
+				return errors.IsNotFound(err)
+			}, time.Second*10, time.Millisecond*250).Should(BeTrue())
+		}
+
+		It("should stop tracking the objects and remove its annotations", func() {
+			myResourceManagerObj := newConfigMapResourceManager("test-on-delete-stop-resource-manager", matchName("on-delete-stop-configmap"), expireAfter("1h"), withOnDelete(resourcemanagmentv1alpha1.OnDeleteStop))
+			Expect(k8sClient.Create(ctx, myResourceManagerObj)).To(Succeed())
+			myConfigMapObj := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-on-delete-stop-configmap",
+				Namespace: "default",
+				Labels:    map[string]string{"name": "on-delete-stop-configmap"},
+			}}
+			Expect(k8sClient.Create(ctx, myConfigMapObj)).To(Succeed())
+			Eventually(func() (map[string]string, error) {
+				err := k8sClient.Get(ctx, client.ObjectKeyFromObject(myConfigMapObj), myConfigMapObj)
+				return myConfigMapObj.Annotations, err
+			}, time.Second*10, time.Millisecond*250).Should(HaveKey(scheduleAnnotation(myResourceManagerObj)))
+
+			deleteResourceManager(myResourceManagerObj)
+
+			Eventually(func() (map[string]string, error) {
+				err := k8sClient.Get(ctx, client.ObjectKeyFromObject(myConfigMapObj), myConfigMapObj)
+				return myConfigMapObj.Annotations, err
+			}, time.Second*10, time.Millisecond*250).ShouldNot(HaveKey(scheduleAnnotation(myResourceManagerObj)))
+		})
+
+		It("should perform the pending actions immediately", func() {
+			myResourceManagerObj := newConfigMapResourceManager("test-on-delete-execute-resource-manager", matchName("on-delete-execute-configmap"), expireAfter("1h"), withOnDelete(resourcemanagmentv1alpha1.OnDeleteExecute))
+			Expect(k8sClient.Create(ctx, myResourceManagerObj)).To(Succeed())
+			myConfigMapObj := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-on-delete-execute-configmap",
+				Namespace: "default",
+				Labels:    map[string]string{"name": "on-delete-execute-configmap"},
+			}}
+			Expect(k8sClient.Create(ctx, myConfigMapObj)).To(Succeed())
+
+			deleteResourceManager(myResourceManagerObj)
+
+			err := k8sClient.Get(ctx, client.ObjectKeyFromObject(myConfigMapObj), &v1.ConfigMap{})
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+		})
+
+		It("should revert the patches performed on the objects", func() {
+			myResourceManagerObj := newConfigMapResourceManager("test-on-delete-revert-resource-manager", matchName("on-delete-revert-configmap"), expireAfter("1s"),
+				withOnDelete(resourcemanagmentv1alpha1.OnDeleteRevert),
+				withPatch(resourcemanagmentv1alpha1.PatchTypeMerge, `{"metadata":{"labels":{"expired":"true"}},"data":{"state":"expired"}}`))
+			Expect(k8sClient.Create(ctx, myResourceManagerObj)).To(Succeed())
+			myConfigMapObj := &v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-on-delete-revert-configmap",
+					Namespace: "default",
+					Labels:    map[string]string{"name": "on-delete-revert-configmap"},
+				},
+				Data: map[string]string{"state": "active"},
+			}
+			Expect(k8sClient.Create(ctx, myConfigMapObj)).To(Succeed())
+			Eventually(func() (map[string]string, error) {
+				err := k8sClient.Get(ctx, client.ObjectKeyFromObject(myConfigMapObj), myConfigMapObj)
+				return myConfigMapObj.Annotations, err
+			}, time.Second*10, time.Millisecond*250).Should(HaveKey(revertAnnotation(myResourceManagerObj)))
+			Expect(myConfigMapObj.Labels).To(HaveKeyWithValue("expired", "true"))
+
+			deleteResourceManager(myResourceManagerObj)
+
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(myConfigMapObj), myConfigMapObj)).To(Succeed())
+			Expect(myConfigMapObj.Labels).To(Equal(map[string]string{"name": "on-delete-revert-configmap"}))
+			Expect(myConfigMapObj.Data).To(Equal(map[string]string{"state": "active"}))
+			Expect(myConfigMapObj.Annotations).NotTo(HaveKey(revertAnnotation(myResourceManagerObj)))
+			Expect(myConfigMapObj.Annotations).NotTo(HaveKey(scheduleAnnotation(myResourceManagerObj)))
+		})
+	})
+
+	Describe("when objects override the expiration", func() {
+		It("should not act on an ignored object", func() {
+			myResourceManagerObj := newConfigMapResourceManager("test-ignore-resource-manager", matchName("ignored-configmap"), expireAfter("1s"))
+			Expect(k8sClient.Create(ctx, myResourceManagerObj)).To(Succeed())
+
+			myConfigMapObj := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+				Name:        "test-ignored-configmap",
+				Namespace:   "default",
+				Labels:      map[string]string{"name": "ignored-configmap"},
+				Annotations: map[string]string{ignoreAnnotation: "true"},
+			}}
+			Expect(k8sClient.Create(ctx, myConfigMapObj)).To(Succeed())
+
+			Eventually(trackedObject(ctx, myResourceManagerObj), time.Second*10, time.Millisecond*250).Should(
+				HaveField("Override", "ignore=true"))
+			Consistently(func() error {
+				return k8sClient.Get(ctx, client.ObjectKeyFromObject(myConfigMapObj), &v1.ConfigMap{})
+			}, time.Second*3, time.Millisecond*500).Should(Succeed())
+			Expect(trackedObject(ctx, myResourceManagerObj)().ExpiresAt).To(BeNil())
+		})
+
+		It("should extend the life of an object until its override is removed", func() {
+			myResourceManagerObj := newConfigMapResourceManager("test-expire-after-resource-manager", matchName("extended-configmap"), expireAfter("1s"))
+			Expect(k8sClient.Create(ctx, myResourceManagerObj)).To(Succeed())
+
+			myConfigMapObj := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+				Name:        "test-extended-configmap",
+				Namespace:   "default",
+				Labels:      map[string]string{"name": "extended-configmap"},
+				Annotations: map[string]string{expireAfterAnnotation: "48h"},
+			}}
+			Expect(k8sClient.Create(ctx, myConfigMapObj)).To(Succeed())
+
+			Eventually(trackedObject(ctx, myResourceManagerObj), time.Second*10, time.Millisecond*250).Should(
+				HaveField("Override", "expire-after=48h"))
+			tracked := trackedObject(ctx, myResourceManagerObj)()
+			Expect(tracked.ExpiresAt).NotTo(BeNil())
+			Expect(tracked.ExpiresAt.Time).To(BeTemporally("~", myConfigMapObj.CreationTimestamp.Add(48*time.Hour), time.Second))
+			Consistently(func() error {
+				return k8sClient.Get(ctx, client.ObjectKeyFromObject(myConfigMapObj), &v1.ConfigMap{})
+			}, time.Second*3, time.Millisecond*500).Should(Succeed())
+
+			// the expiration of the resource manager applies once the override is removed
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(myConfigMapObj), myConfigMapObj)).To(Succeed())
+			delete(myConfigMapObj.Annotations, expireAfterAnnotation)
+			Expect(k8sClient.Update(ctx, myConfigMapObj)).To(Succeed())
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, client.ObjectKeyFromObject(myConfigMapObj), &v1.ConfigMap{})
+				return errors.IsNotFound(err)
+			}, time.Second*10, time.Millisecond*500).Should(BeTrue())
+		})
+
+		It("should act on an object at its expire-at time", func() {
+			myResourceManagerObj := newConfigMapResourceManager("test-expire-at-resource-manager", matchName("expire-at-configmap"), expireAfter("1h"))
+			Expect(k8sClient.Create(ctx, myResourceManagerObj)).To(Succeed())
+
+			expireAt := time.Now().Add(2 * time.Second).UTC().Format(time.RFC3339)
+			myConfigMapObj := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+				Name:        "test-expire-at-configmap",
+				Namespace:   "default",
+				Labels:      map[string]string{"name": "expire-at-configmap"},
+				Annotations: map[string]string{expireAtAnnotation: expireAt},
+			}}
+			Expect(k8sClient.Create(ctx, myConfigMapObj)).To(Succeed())
+
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, client.ObjectKeyFromObject(myConfigMapObj), &v1.ConfigMap{})
+				return errors.IsNotFound(err)
+			}, time.Second*10, time.Millisecond*500).Should(BeTrue())
+		})
+
+		It("should snooze an object up to the maximum extensions", func() {
+			maxExtensions := int32(1)
+			myResourceManagerObj := newConfigMapResourceManager("test-snooze-resource-manager", matchName("snoozed-configmap"), expireAfter("1h"),
+				withLease(resourcemanagmentv1alpha1.LeasePolicy{MaxExtensions: &maxExtensions}))
+			Expect(k8sClient.Create(ctx, myResourceManagerObj)).To(Succeed())
+
+			snoozeUntil := time.Now().Add(2 * time.Hour).UTC().Truncate(time.Second)
+			myConfigMapObj := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+				Name:        "test-snoozed-configmap",
+				Namespace:   "default",
+				Labels:      map[string]string{"name": "snoozed-configmap"},
+				Annotations: map[string]string{snoozeUntilAnnotation: snoozeUntil.Format(time.RFC3339)},
+			}}
+			Expect(k8sClient.Create(ctx, myConfigMapObj)).To(Succeed())
+
+			Eventually(trackedObject(ctx, myResourceManagerObj), time.Second*10, time.Millisecond*250).Should(
+				HaveField("Extensions", int32(1)))
+			Expect(trackedObject(ctx, myResourceManagerObj)().ExpiresAt.Time).To(BeTemporally("==", snoozeUntil))
+
+			// the second extension is rejected and the expiration is kept
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(myConfigMapObj), myConfigMapObj)).To(Succeed())
+			myConfigMapObj.Annotations[snoozeUntilAnnotation] = snoozeUntil.Add(time.Hour).Format(time.RFC3339)
+			Expect(k8sClient.Update(ctx, myConfigMapObj)).To(Succeed())
+			Eventually(trackedObject(ctx, myResourceManagerObj), time.Second*10, time.Millisecond*250).Should(
+				HaveField("Message", ContainSubstring("maximum of 1 extensions")))
+			tracked := trackedObject(ctx, myResourceManagerObj)()
+			Expect(tracked.Extensions).To(Equal(int32(1)))
+			Expect(tracked.ExpiresAt.Time).To(BeTemporally("==", snoozeUntil))
+		})
+
+		It("should shorten a lease to the maximum lifetime", func() {
+			myResourceManagerObj := newConfigMapResourceManager("test-lifetime-resource-manager", matchName("renewed-configmap"), expireAfter("1s"),
+				withLease(resourcemanagmentv1alpha1.LeasePolicy{MaxLifetime: "4s"}))
+			Expect(k8sClient.Create(ctx, myResourceManagerObj)).To(Succeed())
+
+			myConfigMapObj := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+				Name:        "test-renewed-configmap",
+				Namespace:   "default",
+				Labels:      map[string]string{"name": "renewed-configmap"},
+				Annotations: map[string]string{snoozeUntilAnnotation: time.Now().Add(time.Hour).UTC().Format(time.RFC3339)},
+			}}
+			Expect(k8sClient.Create(ctx, myConfigMapObj)).To(Succeed())
+
+			Eventually(trackedObject(ctx, myResourceManagerObj), time.Second*10, time.Millisecond*250).Should(
+				HaveField("Extensions", int32(1)))
+			Expect(trackedObject(ctx, myResourceManagerObj)().ExpiresAt.Time).To(BeTemporally("~", myConfigMapObj.CreationTimestamp.Add(4*time.Second), time.Second))
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, client.ObjectKeyFromObject(myConfigMapObj), &v1.ConfigMap{})
+				return errors.IsNotFound(err)
+			}, time.Second*10, time.Millisecond*500).Should(BeTrue())
+		})
+	})
+
+	Describe("when actions fail", func() {
+		It("should give up once the attempts are exhausted", func() {
+			myResourceManagerObj := newConfigMapResourceManager("test-give-up-resource-manager", matchName("give-up-configmap"), expireAfter("1s"),
+				withPatch(resourcemanagmentv1alpha1.PatchTypeMerge, `{"data":{"team":"{{ .Labels.team }}"}}`), withRetry(2, "1s"))
+			Expect(k8sClient.Create(ctx, myResourceManagerObj)).To(Succeed())
+
+			myConfigMapObj := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-give-up-configmap",
+				Namespace: "default",
+				Labels:    map[string]string{"name": "give-up-configmap"},
+			}}
+			Expect(k8sClient.Create(ctx, myConfigMapObj)).To(Succeed())
+
+			Eventually(trackedObject(ctx, myResourceManagerObj), time.Second*10, time.Millisecond*250).Should(And(
+				HaveField("Attempts", int32(2)),
+				HaveField("LastActionResult", resourcemanagmentv1alpha1.ActionResultFailed),
+				HaveField("Message", ContainSubstring("team"))))
+			Consistently(func() int32 {
+				return trackedObject(ctx, myResourceManagerObj)().Attempts
+			}, time.Second*4, time.Millisecond*500).Should(Equal(int32(2)))
+		})
+
+		It("should retry a failed action until it succeeds", func() {
+			myResourceManagerObj := newConfigMapResourceManager("test-retry-resource-manager", matchName("retried-configmap"), expireAfter("1s"),
+				withPatch(resourcemanagmentv1alpha1.PatchTypeMerge, `{"data":{"team":"{{ .Labels.team }}"}}`), withRetry(5, "1s"))
+			Expect(k8sClient.Create(ctx, myResourceManagerObj)).To(Succeed())
+
+			myConfigMapObj := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-retried-configmap",
+				Namespace: "default",
+				Labels:    map[string]string{"name": "retried-configmap"},
+			}}
+			Expect(k8sClient.Create(ctx, myConfigMapObj)).To(Succeed())
+			Eventually(trackedObject(ctx, myResourceManagerObj), time.Second*10, time.Millisecond*250).Should(
+				HaveField("LastActionResult", resourcemanagmentv1alpha1.ActionResultFailed))
+
+			// the missing label is added, so the next attempt succeeds
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(myConfigMapObj), myConfigMapObj)).To(Succeed())
+			myConfigMapObj.Labels["team"] = "platform"
+			Expect(k8sClient.Update(ctx, myConfigMapObj)).To(Succeed())
+
+			Eventually(trackedObject(ctx, myResourceManagerObj), time.Second*20, time.Millisecond*250).Should(
+				HaveField("LastActionResult", resourcemanagmentv1alpha1.ActionResultSucceeded))
+			Expect(trackedObject(ctx, myResourceManagerObj)().Attempts).To(BeNumerically(">", 1))
+			configMap := &v1.ConfigMap{}
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(myConfigMapObj), configMap)).To(Succeed())
+			Expect(configMap.Data).To(HaveKeyWithValue("team", "platform"))
+		})
+	})
+
+	Describe("when recording events", func() {
+		It("should warn before the action and record its result", func() {
+			myResourceManagerObj := &resourcemanagmentv1alpha1.ResourceManager{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-events-resource-manager",
+					Namespace: "default",
+				},
+				Spec: resourcemanagmentv1alpha1.ResourceManagerSpec{
+					ResourceAPIVersion: "v1",
+					ResourceKind:       "ConfigMap",
+					Selector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{
+							"name": "warned-configmap",
+						},
+					},
+					Action: "delete",
+					Condition: resourcemanagmentv1alpha1.Expiration{
+						ExpireAfter: "4s",
+					},
+					WarnBefore: []string{"2s"},
+				},
+			}
+			Expect(k8sClient.Create(ctx, myResourceManagerObj)).To(Succeed())
+
+			myConfigMapObj := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-warned-configmap",
+				Namespace: "default",
+				Labels:    map[string]string{"name": "warned-configmap"},
+			}}
+			Expect(k8sClient.Create(ctx, myConfigMapObj)).To(Succeed())
+
+			eventReasons := func(name string) func() []string {
+				return func() []string {
+					events := &v1.EventList{}
+					if err := k8sClient.List(ctx, events, client.InNamespace("default")); err != nil {
+						return nil
+					}
+					var reasons []string
+					for _, e := range events.Items {
+						if e.InvolvedObject.Name == name {
+							reasons = append(reasons, e.Reason)
+						}
+					}
+					return reasons
+				}
+			}
+			Eventually(eventReasons(myConfigMapObj.Name), time.Second*10, time.Millisecond*250).Should(ContainElement(reasonExpiring))
+			Eventually(eventReasons(myResourceManagerObj.Name), time.Second*10, time.Millisecond*250).Should(
+				ContainElements(reasonExpiring, reasonActionSucceeded))
+		})
+
+		It("should post the warnings and the action results to the notifications webhook", func() {
+			payloads := make(chan string, 10)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				payloads <- string(body)
+			}))
+			defer server.Close()
+
+			mySecretObj := &v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-notifications-webhook",
+					Namespace: "default",
+				},
+				StringData: map[string]string{"url": server.URL},
+			}
+			Expect(k8sClient.Create(ctx, mySecretObj)).To(Succeed())
+
+			myResourceManagerObj := &resourcemanagmentv1alpha1.ResourceManager{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-notifications-resource-manager",
+					Namespace: "default",
+				},
+				Spec: resourcemanagmentv1alpha1.ResourceManagerSpec{
+					ResourceAPIVersion: "v1",
+					ResourceKind:       "ConfigMap",
+					Selector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{
+							"name": "notified-configmap",
+						},
+					},
+					Action: "delete",
+					Condition: resourcemanagmentv1alpha1.Expiration{
+						ExpireAfter: "4s",
+					},
+					WarnBefore: []string{"2s"},
+					Notifications: &resourcemanagmentv1alpha1.Notifications{
+						SecretRef:       resourcemanagmentv1alpha1.SecretKeyReference{Name: mySecretObj.Name},
+						PayloadTemplate: `{"reason": {{ json .Reason }}, "object": {{ json .Object }}}`,
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, myResourceManagerObj)).To(Succeed())
+
+			myConfigMapObj := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-notified-configmap",
+				Namespace: "default",
+				Labels:    map[string]string{"name": "notified-configmap"},
+			}}
+			Expect(k8sClient.Create(ctx, myConfigMapObj)).To(Succeed())
+
+			Eventually(payloads, time.Second*10).Should(Receive(MatchJSON(`{"reason": "Expiring", "object": "default/test-notified-configmap"}`)))
+			Eventually(payloads, time.Second*10).Should(Receive(MatchJSON(`{"reason": "ActionSucceeded", "object": "default/test-notified-configmap"}`)))
+		})
+	})
+
+	Describe("when exporting metrics", func() {
+		// gauge returns the value of a gauge collected from the running handlers, and false when it is not reported
+		gauge := func(name string, rm string) (float64, bool) {
+			ch := make(chan prometheus.Metric, 100)
+			go func() {
+				handlerMetrics.Collect(ch)
+				close(ch)
+			}()
+			found, value := false, 0.0
+			for metric := range ch {
+				m := &dto.Metric{}
+				Expect(metric.Write(m)).To(Succeed())
+				if strings.Contains(metric.Desc().String(), `"`+name+`"`) && m.GetLabel()[0].GetValue() == rm {
+					found, value = true, m.GetGauge().GetValue()
+				}
+			}
+			return value, found
+		}
+
+		It("should report the tracked objects, the next action and the action results", func() {
+			myResourceManagerObj := &resourcemanagmentv1alpha1.ResourceManager{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-metrics-resource-manager",
+					Namespace: "default",
+				},
+				Spec: resourcemanagmentv1alpha1.ResourceManagerSpec{
+					ResourceAPIVersion: "v1",
+					ResourceKind:       "ConfigMap",
+					Selector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{
+							"name": "measured-configmap",
+						},
+					},
+					Action: "delete",
+					Condition: resourcemanagmentv1alpha1.Expiration{
+						ExpireAfter: "4s",
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, myResourceManagerObj)).To(Succeed())
+			rm := "default/test-metrics-resource-manager"
+
+			myConfigMapObj := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-measured-configmap",
+				Namespace: "default",
+				Labels:    map[string]string{"name": "measured-configmap"},
+			}}
+			Expect(k8sClient.Create(ctx, myConfigMapObj)).To(Succeed())
+
+			Eventually(func() float64 {
+				value, _ := gauge("resourcemanager_tracked_objects", rm)
+				return value
+			}, time.Second*10, time.Millisecond*250).Should(Equal(1.0))
+			Eventually(func() float64 {
+				value, _ := gauge("resourcemanager_next_action_seconds", rm)
+				return value
+			}, time.Second*10, time.Millisecond*250).Should(And(BeNumerically(">", 0), BeNumerically("<=", 4)))
+
+			Eventually(func() float64 {
+				return testutil.ToFloat64(actionsTotal.WithLabelValues(rm, "delete", resourcemanagmentv1alpha1.ActionResultSucceeded))
+			}, time.Second*10, time.Millisecond*250).Should(Equal(1.0))
+			Eventually(func() bool {
+				_, found := gauge("resourcemanager_next_action_seconds", rm)
+				return found
+			}, time.Second*10, time.Millisecond*250).Should(BeFalse())
+		})
+	})
+
+	Describe("when scaling workloads", func() {
+		It("should scale a Deployment down and restore its replicas", func() {
+			myResourceManagerObj := &resourcemanagmentv1alpha1.ResourceManager{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-scale-resource-manager",
+					Namespace: "default",
+				},
+				Spec: resourcemanagmentv1alpha1.ResourceManagerSpec{
+					ResourceAPIVersion: "apps/v1",
+					ResourceKind:       "Deployment",
+					Selector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{
+							"name": "scaled-deployment",
+						},
+					},
+					Action: resourcemanagmentv1alpha1.ActionScale,
+					Scale: &resourcemanagmentv1alpha1.ScaleAction{
+						Replicas: 0,
+						Restore: &resourcemanagmentv1alpha1.Expiration{
+							ExpireAfter: "3s",
+						},
+					},
+					Condition: resourcemanagmentv1alpha1.Expiration{
+						ExpireAfter: "1s",
+					},
+				},
+			}
+			err := k8sClient.Create(ctx, myResourceManagerObj)
+			Expect(err).NotTo(HaveOccurred(), "failed to create test 'ResourceManager' resource")
+
+			replicas := int32(3)
+			labels := map[string]string{"name": "scaled-deployment"}
+			myDeploymentObj := &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-scaled-deployment",
+					Namespace: "default",
+					Labels:    labels,
+				},
+				Spec: appsv1.DeploymentSpec{
+					Replicas: &replicas,
+					Selector: &metav1.LabelSelector{MatchLabels: labels},
+					Template: v1.PodTemplateSpec{
+						ObjectMeta: metav1.ObjectMeta{Labels: labels},
+						Spec: v1.PodSpec{
+							Containers: []v1.Container{{Name: "nginx", Image: "nginx"}},
+						},
+					},
+				},
+			}
+			err = k8sClient.Create(ctx, myDeploymentObj)
+			Expect(err).NotTo(HaveOccurred(), "failed to create test 'deployment' resource")
+
+			deployment := &appsv1.Deployment{}
+			Eventually(func() (int32, error) {
+				err := k8sClient.Get(ctx, client.ObjectKeyFromObject(myDeploymentObj), deployment)
+				if err != nil {
+					return -1, err
+				}
+				return *deployment.Spec.Replicas, nil
+			}, time.Second*10, time.Millisecond*500).Should(BeZero())
+			Expect(deployment.Annotations).To(HaveKeyWithValue(previousReplicasAnnotation, "3"))
+
+			Eventually(func() (int32, error) {
+				err := k8sClient.Get(ctx, client.ObjectKeyFromObject(myDeploymentObj), deployment)
+				if err != nil {
+					return -1, err
+				}
+				return *deployment.Spec.Replicas, nil
+			}, time.Second*10, time.Millisecond*500).Should(Equal(replicas))
+			Eventually(func() (map[string]string, error) {
+				err := k8sClient.Get(ctx, client.ObjectKeyFromObject(myDeploymentObj), deployment)
+				return deployment.Annotations, err
+			}, time.Second*10, time.Millisecond*500).ShouldNot(HaveKey(previousReplicasAnnotation))
+		})
 	})
 })
 
@@ -0,0 +1,132 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/tikalk/resource-manager/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// previousReplicasAnnotation holds the replica count of a scaled object, so it can be restored
+const previousReplicasAnnotation = "resource-management.tikalk.com/previous-replicas"
+
+// scaleSubresource is the subresource of the kinds that can be scaled (ex: Deployment, StatefulSet, ReplicaSet)
+const scaleSubresource = "scale"
+
+// performObjectScale records the replica count of a single object and scales it to the desired replicas
+func (h *ObjectHandler) performObjectScale() error {
+	scale := h.getResourceManager().GetSpec().Scale
+	if scale == nil {
+		return errors.New("objectScale: the scale action is not configured")
+	}
+
+	replicas, err := h.getReplicas()
+	if err != nil {
+		return err
+	}
+	if replicas == scale.Replicas {
+		h.log.Info(trace(fmt.Sprintf("object <%s> already has <%d> replicas", h.fullname, replicas)))
+		return nil
+	}
+
+	// keep the replicas of the first scale, so an object scaled twice is restored to its original size
+	_, ok, err := h.getAnnotation(previousReplicasAnnotation)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		previous := strconv.FormatInt(int64(replicas), 10)
+		if err := h.patchAnnotation(previousReplicasAnnotation, &previous); err != nil {
+			return err
+		}
+	}
+	return h.setReplicas(scale.Replicas)
+}
+
+// performObjectRestore scales a single object back to the replica count recorded before it was scaled
+func (h *ObjectHandler) performObjectRestore() error {
+	value, ok, err := h.getAnnotation(previousReplicasAnnotation)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		h.log.Info(trace(fmt.Sprintf("object <%s> has no previous replicas to restore", h.fullname)))
+		return nil
+	}
+	replicas, err := strconv.ParseInt(value, 10, 32)
+	if err != nil {
+		return fmt.Errorf("objectRestore: invalid previous replicas <%s>: %w", value, err)
+	}
+
+	if err := h.setReplicas(int32(replicas)); err != nil {
+		return err
+	}
+	return h.patchAnnotation(previousReplicasAnnotation, nil)
+}
+
+// getReplicas returns the desired replica count of the object from its scale subresource
+func (h *ObjectHandler) getReplicas() (int32, error) {
+	scale, err := h.resourceClient.Namespace(h.fullname.Namespace).Get(context.Background(), h.fullname.Name, metav1.GetOptions{}, scaleSubresource)
+	if err != nil {
+		return 0, err
+	}
+	replicas, _, err := unstructured.NestedInt64(scale.Object, "spec", "replicas")
+	if err != nil {
+		return 0, fmt.Errorf("objectScale: invalid scale of <%s>: %w", h.fullname, err)
+	}
+	return int32(replicas), nil
+}
+
+// setReplicas sets the desired replica count of the object through its scale subresource
+func (h *ObjectHandler) setReplicas(replicas int32) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"replicas": replicas,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = h.resourceClient.Namespace(h.fullname.Namespace).Patch(context.Background(), h.fullname.Name, types.MergePatchType, patch, metav1.PatchOptions{}, scaleSubresource)
+	return err
+}
+
+// getAnnotation returns an annotation of the live object, the cached object may miss the annotations written by the handler
+func (h *ObjectHandler) getAnnotation(name string) (string, bool, error) {
+	obj, err := h.resourceClient.Namespace(h.fullname.Namespace).Get(context.Background(), h.fullname.Name, metav1.GetOptions{})
+	if err != nil {
+		return "", false, err
+	}
+	value, ok := obj.GetAnnotations()[name]
+	return value, ok, nil
+}
+
+// patchAnnotation sets an annotation of the object, a nil value removes it
+func (h *ObjectHandler) patchAnnotation(name string, value *string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]*string{
+				name: value,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = h.resourceClient.Namespace(h.fullname.Namespace).Patch(context.Background(), h.fullname.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+// scaleRestore returns the restore schedule of the scale action, or nil when the objects are not restored
+func scaleRestore(resourceManager v1alpha1.ResourceManagerObject) *v1alpha1.Expiration {
+	if resourceManager.GetSpec().Action != v1alpha1.ActionScale || resourceManager.GetSpec().Scale == nil {
+		return nil
+	}
+	return resourceManager.GetSpec().Scale.Restore
+}
@@ -0,0 +1,172 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/tikalk/resource-manager/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// scheduleAnnotationPrefix prefixes the annotations holding the schedule of each resource manager on a managed object.
+// The annotation name is the UID of the resource manager, so a recreated resource manager starts a new schedule.
+const scheduleAnnotationPrefix = "schedule.resource-management.tikalk.com/"
+
+// scheduleRecord is the schedule of a resource manager action on a single object.
+// It is persisted on the object, so restarts and leader changes neither skip nor repeat the action.
+type scheduleRecord struct {
+	// DueAt is the time the action is due
+	DueAt metav1.Time `json:"dueAt"`
+	// Expiration is the condition DueAt was calculated from, the record is outdated when it changes
+	Expiration v1alpha1.Expiration `json:"expiration"`
+	// Override is the annotation of the object DueAt was calculated from, the record is outdated when it changes
+	Override string `json:"override,omitempty"`
+	// Lease is the lease annotation of the object DueAt was last extended by
+	Lease string `json:"lease,omitempty"`
+	// Extensions is the number of times DueAt was extended by a lease
+	Extensions int32 `json:"extensions,omitempty"`
+	// Attempts is the number of failed or successful attempts of the action
+	Attempts int32 `json:"attempts,omitempty"`
+	// RetryAt is the time a failed action is retried, empty when it is not retried
+	RetryAt *metav1.Time `json:"retryAt,omitempty"`
+	// ExecutedAt is the time the action was performed
+	ExecutedAt *metav1.Time `json:"executedAt,omitempty"`
+	// RestoreAt is the time a scaled object is due to be restored
+	RestoreAt *metav1.Time `json:"restoreAt,omitempty"`
+	// RestoreAttempts is the number of attempts of the restore, a failed restore is retried by pushing RestoreAt back
+	RestoreAttempts int32 `json:"restoreAttempts,omitempty"`
+	// RestoredAt is the time a scaled object was restored
+	RestoredAt *metav1.Time `json:"restoredAt,omitempty"`
+	// LastRunAt is the time the action was last performed on the object, it is carried to the next occurrences of a recurring action
+	LastRunAt *metav1.Time `json:"lastRunAt,omitempty"`
+	// WarnedFor is the expiration the last warning was recorded for, and WarnedLead the lead time of that warning.
+	// The warnings of the same expiration with this lead time or a longer one are not recorded again (ex: after a restart).
+	WarnedFor  *metav1.Time `json:"warnedFor,omitempty"`
+	WarnedLead string       `json:"warnedLead,omitempty"`
+}
+
+// scheduleAnnotation returns the name of the annotation holding the schedule of the resource manager
+func scheduleAnnotation(resourceManager v1alpha1.ResourceManagerObject) string {
+	return scheduleAnnotationPrefix + string(resourceManager.GetUID())
+}
+
+// loadScheduleRecord returns the schedule of the object, or nil when it is missing or outdated.
+// The schedule last saved by the handler takes precedence over the annotation of the observed object,
+// which does not show the schedules saved since it was observed.
+// An outdated schedule of an action that was performed and does not recur is updated instead, so the action is not performed again.
+func (h *ObjectHandler) loadScheduleRecord(override *objectOverride) *scheduleRecord {
+	record := h.lastScheduleRecord()
+	if record == nil {
+		return nil
+	}
+	if !reflect.DeepEqual(record.Expiration, h.getResourceManager().GetSpec().Condition) || record.Override != override.String() {
+		if record.ExecutedAt == nil {
+			h.log.Info(trace(fmt.Sprintf("object <%s> schedule is outdated. Ignoring...", h.fullname)))
+			return nil
+		}
+		return h.updatePerformedRecord(record, override)
+	}
+	h.rememberScheduleRecord(record)
+	return record
+}
+
+// updatePerformedRecord recalculates the due time of the outdated schedule of a performed action, and keeps the time it was performed.
+// It returns nil when the updated action recurs, its next occurrence is scheduled from the new expiration.
+func (h *ObjectHandler) updatePerformedRecord(record *scheduleRecord, override *objectOverride) *scheduleRecord {
+	updated := *record
+	updated.Expiration = h.getResourceManager().GetSpec().Condition
+	updated.Override = override.String()
+	if h.recurring(&updated) {
+		h.log.Info(trace(fmt.Sprintf("object <%s> schedule is outdated. Ignoring...", h.fullname)))
+		return nil
+	}
+
+	dueAt, err := h.calculateObjectExpiration(override, time.Now())
+	if err != nil {
+		h.log.Error(err, trace(fmt.Sprintf("object <%s> schedule updating failed", h.fullname)))
+		return nil
+	}
+	updated.DueAt = metav1.NewTime(dueAt)
+	h.log.Info(trace(fmt.Sprintf("object <%s> schedule is outdated, the action performed at <%s> is kept", h.fullname, record.ExecutedAt)))
+	h.saveScheduleRecord(&updated)
+	return &updated
+}
+
+// lastScheduleRecord returns a copy of the schedule last saved by the handler, or the schedule persisted on the observed object.
+// It returns nil when there is none, an outdated schedule is returned as well.
+func (h *ObjectHandler) lastScheduleRecord() *scheduleRecord {
+	h.mu.Lock()
+	saved := h.record
+	h.mu.Unlock()
+	if saved != nil {
+		record := *saved
+		return &record
+	}
+
+	accessor, err := meta.Accessor(h.getObject())
+	if err != nil {
+		return nil
+	}
+	value, ok := accessor.GetAnnotations()[scheduleAnnotation(h.getResourceManager())]
+	if !ok {
+		return nil
+	}
+	record := &scheduleRecord{}
+	if err := json.Unmarshal([]byte(value), record); err != nil {
+		h.log.Error(err, trace(fmt.Sprintf("object <%s> schedule annotation is invalid. Ignoring...", h.fullname)))
+		return nil
+	}
+	return record
+}
+
+// lastRunAt returns the time the action was last performed on the object, nil when it was never performed
+func (h *ObjectHandler) lastRunAt() *metav1.Time {
+	if record := h.lastScheduleRecord(); record != nil {
+		return record.LastRunAt
+	}
+	return nil
+}
+
+// rememberScheduleRecord keeps a copy of the schedule in memory, the tasks keep updating their own copy
+func (h *ObjectHandler) rememberScheduleRecord(record *scheduleRecord) {
+	saved := *record
+	h.mu.Lock()
+	h.record = &saved
+	h.mu.Unlock()
+	h.notify()
+}
+
+// saveScheduleRecord persists the schedule on the object, failures are logged and the schedule is kept in memory
+func (h *ObjectHandler) saveScheduleRecord(record *scheduleRecord) {
+	h.rememberScheduleRecord(record)
+	if h.getResourceManager().GetSpec().DryRun {
+		return
+	}
+
+	value, err := json.Marshal(record)
+	if err != nil {
+		h.log.Error(err, trace(fmt.Sprintf("object <%s> schedule encoding failed", h.fullname)))
+		return
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				scheduleAnnotation(h.getResourceManager()): string(value),
+			},
+		},
+	})
+	if err != nil {
+		h.log.Error(err, trace(fmt.Sprintf("object <%s> schedule encoding failed", h.fullname)))
+		return
+	}
+
+	_, err = h.resourceClient.Namespace(h.fullname.Namespace).Patch(context.Background(), h.fullname.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		h.log.Error(err, trace(fmt.Sprintf("object <%s> schedule persisting failed", h.fullname)))
+	}
+}
@@ -0,0 +1,246 @@
+package scheduler
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultWorkers is the number of workers running the due tasks when it is not configured
+const DefaultWorkers = 10
+
+// Key identifies a scheduled task, tasks of the same owner can be canceled together
+type Key struct {
+	Owner string
+	Name  string
+}
+
+// task is a function waiting in the queue for its due time
+type task struct {
+	key   Key
+	dueAt time.Time
+	run   func()
+	// index is the position of the task in the queue
+	index int
+}
+
+// taskQueue is a min-heap of tasks ordered by their due time
+type taskQueue []*task
+
+func (q taskQueue) Len() int { return len(q) }
+
+func (q taskQueue) Less(i, j int) bool { return q[i].dueAt.Before(q[j].dueAt) }
+
+func (q taskQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *taskQueue) Push(x interface{}) {
+	t := x.(*task)
+	t.index = len(*q)
+	*q = append(*q, t)
+}
+
+func (q *taskQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	t := old[n-1]
+	old[n-1] = nil
+	t.index = -1
+	*q = old[:n-1]
+	return t
+}
+
+// Scheduler runs tasks when they are due.
+// All the tasks wait in a single queue served by one timer, and a bounded pool of workers runs the due tasks.
+type Scheduler struct {
+	workers int
+
+	lock  sync.Mutex
+	queue taskQueue
+	tasks map[string]map[string]*task
+
+	// wakeup signals the timer loop that the head of the queue may have changed
+	wakeup chan struct{}
+	// due passes the due tasks to the workers
+	due chan *task
+}
+
+// New creates a scheduler with the given number of workers
+func New(workers int) *Scheduler {
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	return &Scheduler{
+		workers: workers,
+		tasks:   make(map[string]map[string]*task),
+		wakeup:  make(chan struct{}, 1),
+		due:     make(chan *task),
+	}
+}
+
+// Schedule adds a task that runs at dueAt, a task with the same key is replaced.
+// A task that is already due runs as soon as a worker is available.
+func (s *Scheduler) Schedule(key Key, dueAt time.Time, run func()) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if t := s.find(key); t != nil {
+		t.dueAt = dueAt
+		t.run = run
+		heap.Fix(&s.queue, t.index)
+	} else {
+		t = &task{key: key, dueAt: dueAt, run: run}
+		heap.Push(&s.queue, t)
+		if s.tasks[key.Owner] == nil {
+			s.tasks[key.Owner] = make(map[string]*task)
+		}
+		s.tasks[key.Owner][key.Name] = t
+	}
+	s.signal()
+}
+
+// Cancel removes a task that is not due yet, it returns false when the task is not scheduled
+func (s *Scheduler) Cancel(key Key) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	t := s.find(key)
+	if t == nil {
+		return false
+	}
+	s.remove(t)
+	return true
+}
+
+// CancelOwner removes all the tasks of the owner that are not due yet
+func (s *Scheduler) CancelOwner(owner string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, t := range s.tasks[owner] {
+		s.remove(t)
+	}
+}
+
+// DueAt returns the due time of a scheduled task
+func (s *Scheduler) DueAt(key Key) (time.Time, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if t := s.find(key); t != nil {
+		return t.dueAt, true
+	}
+	return time.Time{}, false
+}
+
+// Len returns the number of tasks waiting for their due time
+func (s *Scheduler) Len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return len(s.queue)
+}
+
+// Start runs the timer loop and the workers until the context is done, it implements manager.Runnable
+func (s *Scheduler) Start(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for i := 0; i < s.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.work(ctx)
+		}()
+	}
+
+	s.dispatch(ctx)
+	wg.Wait()
+	return nil
+}
+
+// dispatch waits for the head of the queue to be due and hands the due tasks to the workers
+func (s *Scheduler) dispatch(ctx context.Context) {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		var due []*task
+		wait := time.Hour
+
+		s.lock.Lock()
+		now := time.Now()
+		for len(s.queue) > 0 && !s.queue[0].dueAt.After(now) {
+			t := s.queue[0]
+			s.remove(t)
+			due = append(due, t)
+		}
+		if len(s.queue) > 0 {
+			wait = s.queue[0].dueAt.Sub(now)
+		}
+		s.lock.Unlock()
+
+		for _, t := range due {
+			select {
+			case s.due <- t:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if len(due) > 0 {
+			// time passed while the workers were busy
+			continue
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wakeup:
+		case <-timer.C:
+		}
+	}
+}
+
+// work runs the due tasks until the context is done
+func (s *Scheduler) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case t := <-s.due:
+			t.run()
+		}
+	}
+}
+
+// find returns the scheduled task of the key, the lock must be held
+func (s *Scheduler) find(key Key) *task {
+	return s.tasks[key.Owner][key.Name]
+}
+
+// remove deletes the task from the queue and the index, the lock must be held
+func (s *Scheduler) remove(t *task) {
+	heap.Remove(&s.queue, t.index)
+	delete(s.tasks[t.key.Owner], t.key.Name)
+	if len(s.tasks[t.key.Owner]) == 0 {
+		delete(s.tasks, t.key.Owner)
+	}
+}
+
+// signal wakes the timer loop up without blocking
+func (s *Scheduler) signal() {
+	select {
+	case s.wakeup <- struct{}{}:
+	default:
+	}
+}
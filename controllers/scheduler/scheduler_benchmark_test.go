@@ -0,0 +1,83 @@
+package scheduler_test
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tikalk/resource-manager/controllers/scheduler"
+)
+
+// The memory footprint is accurate with -benchtime 1x only, as later iterations reuse the stacks of exited goroutines:
+//
+//	go test ./controllers/scheduler -run xxx -bench . -benchtime 1x
+
+// trackedObjects is the number of objects tracked by the benchmarks
+const trackedObjects = 10000
+
+// reportFootprint reports the memory (heap and goroutine stacks) per tracked object and the goroutines added since before
+func reportFootprint(b *testing.B, before runtime.MemStats, goroutinesBefore int) {
+	var after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	used := int64(after.HeapAlloc+after.StackInuse) - int64(before.HeapAlloc+before.StackInuse)
+	b.ReportMetric(float64(used)/trackedObjects, "bytes/object")
+	b.ReportMetric(float64(runtime.NumGoroutine()-goroutinesBefore), "goroutines")
+}
+
+// BenchmarkScheduler tracks 10k objects with the central scheduler
+func BenchmarkScheduler(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		var before runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		goroutinesBefore := runtime.NumGoroutine()
+
+		s := scheduler.New(scheduler.DefaultWorkers)
+		go s.Start(ctx)
+		dueAt := time.Now().Add(time.Hour)
+		for j := 0; j < trackedObjects; j++ {
+			s.Schedule(scheduler.Key{Owner: fmt.Sprintf("default/object-%d", j), Name: "expire"}, dueAt.Add(time.Duration(j)*time.Millisecond), func() {})
+		}
+
+		b.StopTimer()
+		reportFootprint(b, before, goroutinesBefore)
+		cancel()
+		b.StartTimer()
+	}
+}
+
+// BenchmarkGoroutineTimers tracks 10k objects with a goroutine blocked on a timer per object, as a baseline
+func BenchmarkGoroutineTimers(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		stopper := make(chan struct{})
+		var before runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		goroutinesBefore := runtime.NumGoroutine()
+
+		var started sync.WaitGroup
+		started.Add(trackedObjects)
+		for j := 0; j < trackedObjects; j++ {
+			wait := time.Hour + time.Duration(j)*time.Millisecond
+			go func() {
+				timer := time.After(wait)
+				started.Done()
+				select {
+				case <-stopper:
+				case <-timer:
+				}
+			}()
+		}
+		started.Wait()
+
+		b.StopTimer()
+		reportFootprint(b, before, goroutinesBefore)
+		close(stopper)
+		b.StartTimer()
+	}
+}
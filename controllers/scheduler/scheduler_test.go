@@ -0,0 +1,139 @@
+package scheduler_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"sigs.k8s.io/controller-runtime/pkg/envtest/printer"
+
+	"github.com/tikalk/resource-manager/controllers/scheduler"
+)
+
+var _ = Context("Testing scheduler", func() {
+	var s *scheduler.Scheduler
+	var cancel context.CancelFunc
+
+	BeforeEach(func() {
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		s = scheduler.New(2)
+		go func() {
+			defer GinkgoRecover()
+			Expect(s.Start(ctx)).To(Succeed())
+		}()
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	Describe("running tasks", func() {
+		It("runs a task when it is due", func() {
+			// the task runs on a worker, the time it ran is checked by the test
+			ranAt := make(chan time.Time, 1)
+			dueAt := time.Now().Add(200 * time.Millisecond)
+			s.Schedule(scheduler.Key{Owner: "rm", Name: "a"}, dueAt, func() {
+				ranAt <- time.Now()
+			})
+			var at time.Time
+			Eventually(ranAt, time.Second).Should(Receive(&at))
+			Expect(at).Should(BeTemporally(">=", dueAt))
+			Expect(s.Len()).To(Equal(0))
+		})
+
+		It("runs a task that is already due immediately", func() {
+			var ran int32
+			s.Schedule(scheduler.Key{Owner: "rm", Name: "a"}, time.Now().Add(-time.Hour), func() {
+				atomic.StoreInt32(&ran, 1)
+			})
+			Eventually(func() int32 { return atomic.LoadInt32(&ran) }, 100*time.Millisecond).Should(Equal(int32(1)))
+		})
+
+		It("runs tasks in the order of their due time", func() {
+			var lock sync.Mutex
+			var order []string
+			record := func(name string) func() {
+				return func() {
+					lock.Lock()
+					defer lock.Unlock()
+					order = append(order, name)
+				}
+			}
+			now := time.Now()
+			s.Schedule(scheduler.Key{Owner: "rm", Name: "c"}, now.Add(300*time.Millisecond), record("c"))
+			s.Schedule(scheduler.Key{Owner: "rm", Name: "a"}, now.Add(100*time.Millisecond), record("a"))
+			s.Schedule(scheduler.Key{Owner: "rm", Name: "b"}, now.Add(200*time.Millisecond), record("b"))
+
+			Eventually(func() []string {
+				lock.Lock()
+				defer lock.Unlock()
+				return append([]string(nil), order...)
+			}, time.Second).Should(Equal([]string{"a", "b", "c"}))
+		})
+
+		It("never runs more tasks than workers at once", func() {
+			var running, maxRunning int32
+			var wg sync.WaitGroup
+			for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+				wg.Add(1)
+				s.Schedule(scheduler.Key{Owner: "rm", Name: name}, time.Now(), func() {
+					defer wg.Done()
+					current := atomic.AddInt32(&running, 1)
+					for {
+						max := atomic.LoadInt32(&maxRunning)
+						if current <= max || atomic.CompareAndSwapInt32(&maxRunning, max, current) {
+							break
+						}
+					}
+					time.Sleep(50 * time.Millisecond)
+					atomic.AddInt32(&running, -1)
+				})
+			}
+			wg.Wait()
+			Expect(atomic.LoadInt32(&maxRunning)).To(Equal(int32(2)))
+		})
+	})
+
+	Describe("changing tasks", func() {
+		It("replaces a task scheduled with the same key", func() {
+			var ran int32
+			key := scheduler.Key{Owner: "rm", Name: "a"}
+			s.Schedule(key, time.Now().Add(time.Hour), func() { atomic.StoreInt32(&ran, 1) })
+			s.Schedule(key, time.Now().Add(100*time.Millisecond), func() { atomic.StoreInt32(&ran, 2) })
+			Expect(s.Len()).To(Equal(1))
+			Eventually(func() int32 { return atomic.LoadInt32(&ran) }, time.Second).Should(Equal(int32(2)))
+		})
+
+		It("does not run a canceled task", func() {
+			var ran int32
+			key := scheduler.Key{Owner: "rm", Name: "a"}
+			s.Schedule(key, time.Now().Add(100*time.Millisecond), func() { atomic.StoreInt32(&ran, 1) })
+			Expect(s.Cancel(key)).To(BeTrue())
+			Expect(s.Cancel(key)).To(BeFalse())
+			Consistently(func() int32 { return atomic.LoadInt32(&ran) }, 300*time.Millisecond).Should(Equal(int32(0)))
+		})
+
+		It("cancels all the tasks of an owner", func() {
+			s.Schedule(scheduler.Key{Owner: "rm1", Name: "a"}, time.Now().Add(time.Hour), func() {})
+			s.Schedule(scheduler.Key{Owner: "rm1", Name: "b"}, time.Now().Add(time.Hour), func() {})
+			s.Schedule(scheduler.Key{Owner: "rm2", Name: "a"}, time.Now().Add(time.Hour), func() {})
+			s.CancelOwner("rm1")
+			Expect(s.Len()).To(Equal(1))
+			_, ok := s.DueAt(scheduler.Key{Owner: "rm2", Name: "a"})
+			Expect(ok).To(BeTrue())
+		})
+	})
+})
+
+func TestScheduler(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecsWithDefaultAndCustomReporters(t,
+		"Scheduler Suite",
+		[]Reporter{printer.NewlineReporter{}})
+}
@@ -36,6 +36,8 @@ import (
 	ctrl "sigs.k8s.io/controller-runtime"
 
 	resourcemanagmentv1alpha1 "github.com/tikalk/resource-manager/api/v1alpha1"
+	"github.com/tikalk/resource-manager/controllers/notifier"
+	"github.com/tikalk/resource-manager/controllers/scheduler"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -87,8 +89,8 @@ var _ = AfterSuite(func() {
 
 // SetupTest will set up a testing environment.
 // This includes:
-// * starting the 'ResourceManagerReconciler'
-// * stopping the 'ResourceManagerReconciler" after the test ends
+// * starting the 'ResourceManagerReconciler' and the 'ClusterResourceManagerReconciler'
+// * stopping the reconcilers after the test ends
 // Call this function at the start of each of your tests.
 func SetupTest(ctx context.Context) {
 	var cancelFunc context.CancelFunc
@@ -97,12 +99,29 @@ func SetupTest(ctx context.Context) {
 		mgr, err := ctrl.NewManager(cfg, ctrl.Options{})
 		Expect(err).NotTo(HaveOccurred(), "failed to create manager")
 
+		actionScheduler := scheduler.New(scheduler.DefaultWorkers)
+		Expect(mgr.Add(actionScheduler)).To(Succeed(), "failed to add scheduler")
+		webhookNotifier := notifier.New(notifier.DefaultWorkers, logf.Log.WithName("notifier"))
+		Expect(mgr.Add(webhookNotifier)).To(Succeed(), "failed to add notifier")
+
 		controller := &ResourceManagerReconciler{
-			Client: mgr.GetClient(),
+			Client:    mgr.GetClient(),
+			Scheduler: actionScheduler,
+			Notifier:  webhookNotifier,
 		}
 		err = controller.SetupWithManager(mgr)
 		Expect(err).NotTo(HaveOccurred(), "failed to setup controller")
 
+		clusterController := &ClusterResourceManagerReconciler{
+			ResourceManagerReconciler: ResourceManagerReconciler{
+				Client:    mgr.GetClient(),
+				Scheduler: actionScheduler,
+				Notifier:  webhookNotifier,
+			},
+		}
+		err = clusterController.SetupWithManager(mgr)
+		Expect(err).NotTo(HaveOccurred(), "failed to setup cluster controller")
+
 		ctx, cancelFunc = context.WithCancel(ctx)
 		go func() {
 			err := mgr.Start(ctx)
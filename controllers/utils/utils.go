@@ -3,6 +3,8 @@ package utils
 import (
 	"fmt"
 	"time"
+
+	"github.com/robfig/cron/v3"
 )
 
 // IsObjExpired check if object has expired
@@ -38,3 +40,59 @@ func IsIntervalOccurred(now time.Time, timeframe string) (err error, secondsUnti
 	//}
 	return nil, secondsUntilTimeframe
 }
+
+// NextScheduleTime returns the first occurrence of a standard 5-field cron schedule after now.
+// The schedule is evaluated in the location of now.
+func NextScheduleTime(now time.Time, schedule string) (time.Time, error) {
+	sched, err := cron.ParseStandard(schedule)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("cannot parse schedule <%s>: %w", schedule, err)
+	}
+	next := sched.Next(now)
+	if next.IsZero() {
+		return next, fmt.Errorf("schedule <%s> has no next occurrence", schedule)
+	}
+	return next, nil
+}
+
+// InWindow returns true when now is inside a window that opens on the start cron schedule and closes on the end cron schedule,
+// that is when the window closes before it opens again. The schedules are evaluated in the location of now.
+func InWindow(now time.Time, start string, end string) (bool, error) {
+	nextStart, err := NextScheduleTime(now, start)
+	if err != nil {
+		return false, err
+	}
+	nextEnd, err := NextScheduleTime(now, end)
+	if err != nil {
+		return false, err
+	}
+	return nextEnd.Before(nextStart), nil
+}
+
+// NextTimeOfDay returns the first occurrence of a "15:04" time of day after now.
+// The time of day is evaluated in the location of now, so it keeps the wall-clock time across DST transitions.
+func NextTimeOfDay(now time.Time, timeOfDay string) (time.Time, error) {
+	at, err := time.Parse("15:04", timeOfDay)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("cannot parse time of day <%s>: %w", timeOfDay, err)
+	}
+	next := time.Date(now.Year(), now.Month(), now.Day(), at.Hour(), at.Minute(), 0, 0, now.Location())
+	if !next.After(now) {
+		// tomorrow, a day is not always 24 hours long
+		next = time.Date(now.Year(), now.Month(), now.Day()+1, at.Hour(), at.Minute(), 0, 0, now.Location())
+	}
+	return next, nil
+}
+
+// Backoff returns the delay before the retry that follows the given failed attempt (1 for the first attempt).
+// The delay starts at initial, doubles on every attempt and is capped at max.
+func Backoff(initial time.Duration, max time.Duration, attempt int32) time.Duration {
+	delay := initial
+	for i := int32(1); i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
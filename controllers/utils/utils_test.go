@@ -4,6 +4,7 @@ import (
 	"github.com/tikalk/resource-manager/controllers/utils"
 	"testing"
 	"time"
+	_ "time/tzdata"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -27,6 +28,152 @@ var _ = Context("Testing utils", func() {
 		})
 	})
 
+	Describe("testing schedule next fire time", func() {
+		// Wednesday
+		now := time.Date(2022, 8, 17, 15, 54, 0, 0, time.UTC)
+
+		It("should fire on the next weekday evening", func() {
+			next, err := utils.NextScheduleTime(now, "0 20 * * 1-5")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(next).To(Equal(time.Date(2022, 8, 17, 20, 0, 0, 0, time.UTC)))
+
+			// Friday evening is followed by Monday evening
+			next, err = utils.NextScheduleTime(time.Date(2022, 8, 19, 20, 0, 0, 0, time.UTC), "0 20 * * 1-5")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(next).To(Equal(time.Date(2022, 8, 22, 20, 0, 0, 0, time.UTC)))
+		})
+
+		It("should match either the day of month or the day of week", func() {
+			// the standard cron syntax cannot express "first Sunday of the month", 1-7 OR Sunday is matched
+			next, err := utils.NextScheduleTime(now, "0 0 1-7 * SUN")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(next).To(Equal(time.Date(2022, 8, 21, 0, 0, 0, 0, time.UTC)))
+		})
+
+		It("should fire every 6 hours", func() {
+			next, err := utils.NextScheduleTime(now, "0 */6 * * *")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(next).To(Equal(time.Date(2022, 8, 17, 18, 0, 0, 0, time.UTC)))
+		})
+
+		It("should fire strictly after now", func() {
+			next, err := utils.NextScheduleTime(time.Date(2022, 8, 17, 18, 0, 0, 0, time.UTC), "0 */6 * * *")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(next).To(Equal(time.Date(2022, 8, 18, 0, 0, 0, 0, time.UTC)))
+		})
+
+		It("should evaluate the schedule in the location of now", func() {
+			jerusalem := time.FixedZone("IDT", 3*60*60)
+			next, err := utils.NextScheduleTime(now.In(jerusalem), "0 20 * * *")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(next).To(Equal(time.Date(2022, 8, 17, 20, 0, 0, 0, jerusalem)))
+		})
+
+		It("should reject an invalid schedule", func() {
+			_, err := utils.NextScheduleTime(now, "0 20 * *")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("testing time zones", func() {
+		var jerusalem, newYork *time.Location
+
+		BeforeEach(func() {
+			var err error
+			jerusalem, err = time.LoadLocation("Asia/Jerusalem")
+			Expect(err).NotTo(HaveOccurred())
+			newYork, err = time.LoadLocation("America/New_York")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should fire later today", func() {
+			next, err := utils.NextTimeOfDay(time.Date(2022, 8, 17, 15, 54, 0, 0, jerusalem), "20:00")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(next).To(Equal(time.Date(2022, 8, 17, 20, 0, 0, 0, jerusalem)))
+			Expect(next.UTC()).To(Equal(time.Date(2022, 8, 17, 17, 0, 0, 0, time.UTC)))
+		})
+
+		It("should fire tomorrow once the time of day passed", func() {
+			next, err := utils.NextTimeOfDay(time.Date(2022, 8, 17, 20, 0, 0, 0, jerusalem), "20:00")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(next).To(Equal(time.Date(2022, 8, 18, 20, 0, 0, 0, jerusalem)))
+		})
+
+		It("should keep the wall-clock time when the clocks are set back", func() {
+			// 2022-10-30 is 25 hours long in Jerusalem
+			next, err := utils.NextTimeOfDay(time.Date(2022, 10, 30, 0, 30, 0, 0, jerusalem), "00:15")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(next).To(Equal(time.Date(2022, 10, 31, 0, 15, 0, 0, jerusalem)))
+			Expect(next.Sub(time.Date(2022, 10, 30, 0, 15, 0, 0, jerusalem))).To(Equal(25 * time.Hour))
+		})
+
+		It("should keep the wall-clock time when the clocks are set forward", func() {
+			// 2022-03-13 is 23 hours long in New York
+			next, err := utils.NextTimeOfDay(time.Date(2022, 3, 12, 20, 0, 0, 0, newYork), "20:00")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(next).To(Equal(time.Date(2022, 3, 13, 20, 0, 0, 0, newYork)))
+			Expect(next.UTC()).To(Equal(time.Date(2022, 3, 14, 0, 0, 0, 0, time.UTC)))
+		})
+
+		It("should evaluate a cron schedule across a DST transition", func() {
+			next, err := utils.NextScheduleTime(time.Date(2022, 11, 5, 20, 0, 0, 0, newYork), "0 20 * * *")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(next).To(Equal(time.Date(2022, 11, 6, 20, 0, 0, 0, newYork)))
+			Expect(next.UTC()).To(Equal(time.Date(2022, 11, 7, 1, 0, 0, 0, time.UTC)))
+		})
+	})
+
+	Describe("testing time windows", func() {
+		// between 20:00 and 07:00 on weekdays, and all weekend
+		const start, end = "0 20 * * 1-5", "0 7 * * 1-5"
+
+		It("should be inside the window at night", func() {
+			// 2022-08-16 is a Tuesday
+			inside, err := utils.InWindow(time.Date(2022, 8, 16, 23, 0, 0, 0, time.UTC), start, end)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(inside).To(BeTrue())
+		})
+
+		It("should be outside the window during the day", func() {
+			inside, err := utils.InWindow(time.Date(2022, 8, 16, 12, 0, 0, 0, time.UTC), start, end)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(inside).To(BeFalse())
+		})
+
+		It("should be inside the window all weekend", func() {
+			inside, err := utils.InWindow(time.Date(2022, 8, 20, 12, 0, 0, 0, time.UTC), start, end)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(inside).To(BeTrue())
+		})
+
+		It("should be inside the window once it opens and outside once it closes", func() {
+			inside, err := utils.InWindow(time.Date(2022, 8, 16, 20, 0, 0, 0, time.UTC), start, end)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(inside).To(BeTrue())
+			inside, err = utils.InWindow(time.Date(2022, 8, 17, 7, 0, 0, 0, time.UTC), start, end)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(inside).To(BeFalse())
+		})
+
+		It("should reject an invalid schedule", func() {
+			_, err := utils.InWindow(time.Now(), start, "every morning")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("testing retry backoff", func() {
+		It("should double the delay on every attempt", func() {
+			Expect(utils.Backoff(10*time.Second, time.Hour, 1)).To(Equal(10 * time.Second))
+			Expect(utils.Backoff(10*time.Second, time.Hour, 2)).To(Equal(20 * time.Second))
+			Expect(utils.Backoff(10*time.Second, time.Hour, 4)).To(Equal(80 * time.Second))
+		})
+
+		It("should cap the delay", func() {
+			Expect(utils.Backoff(10*time.Second, time.Minute, 4)).To(Equal(time.Minute))
+			Expect(utils.Backoff(10*time.Second, time.Minute, 1000)).To(Equal(time.Minute))
+		})
+	})
+
 })
 
 func TestUtils(t *testing.T) {
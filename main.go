@@ -19,6 +19,8 @@ package main
 import (
 	"flag"
 	"os"
+	// embed the time zone database, so time zones can be loaded in minimal images
+	_ "time/tzdata"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -33,6 +35,8 @@ import (
 
 	resourcemanagmentv1alpha1 "github.com/tikalk/resource-manager/api/v1alpha1"
 	"github.com/tikalk/resource-manager/controllers"
+	"github.com/tikalk/resource-manager/controllers/notifier"
+	"github.com/tikalk/resource-manager/controllers/scheduler"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -52,11 +56,13 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var actionWorkers int
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.IntVar(&actionWorkers, "action-workers", scheduler.DefaultWorkers, "The number of actions that may be performed on managed objects at once.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -78,13 +84,50 @@ func main() {
 		os.Exit(1)
 	}
 
+	// the actions of all the resource managers are performed by the same workers
+	actionScheduler := scheduler.New(actionWorkers)
+	if err := mgr.Add(actionScheduler); err != nil {
+		setupLog.Error(err, "unable to add scheduler")
+		os.Exit(1)
+	}
+	webhookNotifier := notifier.New(notifier.DefaultWorkers, ctrl.Log.WithName("notifier"))
+	if err := mgr.Add(webhookNotifier); err != nil {
+		setupLog.Error(err, "unable to add notifier")
+		os.Exit(1)
+	}
+
 	if err = (&controllers.ResourceManagerReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:    mgr.GetClient(),
+		Scheme:    mgr.GetScheme(),
+		Scheduler: actionScheduler,
+		Notifier:  webhookNotifier,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "ResourceManager")
 		os.Exit(1)
 	}
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&resourcemanagmentv1alpha1.ResourceManager{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "ResourceManager")
+			os.Exit(1)
+		}
+	}
+	if err = (&controllers.ClusterResourceManagerReconciler{
+		ResourceManagerReconciler: controllers.ResourceManagerReconciler{
+			Client:    mgr.GetClient(),
+			Scheme:    mgr.GetScheme(),
+			Scheduler: actionScheduler,
+			Notifier:  webhookNotifier,
+		},
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ClusterResourceManager")
+		os.Exit(1)
+	}
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&resourcemanagmentv1alpha1.ClusterResourceManager{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "ClusterResourceManager")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {